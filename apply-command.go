@@ -5,7 +5,123 @@
 
 package main
 
-// Handle "neph apply host configfile dtbfile"
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Handle "neph apply host configfile dtbfile [--block name] [--comment-style style] [--dry-run]"
 func commandApply(host string, options []string) Exitcode {
+	args := positionalArgs(options)
+	if len(args) < 2 {
+		fmt.Printf("neph apply requires a configfile and a dtbfile\n")
+		fmt.Printf("Try neph help\n")
+		return CLI_BAD_ARGUMENTS
+	}
+	configFile := args[0]
+	dtbFile := args[1]
+
+	blockName := optionValue(options, "--block", DEFAULT_BLOCK_NAME)
+	if !isValidBlockName(blockName) {
+		fmt.Printf("invalid block name '%s', use only letters, digits, '.', '_' and '-'\n", blockName)
+		return CLI_BAD_ARGUMENTS
+	}
+	style, exitCode := commentStyleOption(options)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	blockText, err := ioutil.ReadFile(dtbFile)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("DTB file %s does not exist\n", dtbFile)
+		return DTB_FILE_MISSING
+	} else if err != nil {
+		fmt.Printf("unable to read DTB file %s: %v\n", dtbFile, err)
+		return FS_FAILURE
+	}
+
+	dryRun := hasOption(options, "--dry-run")
+
+	if isLocalhost(host) {
+		return applyLocalBlock(configFile, blockName, style, string(blockText), dryRun)
+	} else {
+		return applyRemoteBlock(host, configFile, blockName, style, dtbFile, dryRun)
+	}
+}
+
+// Replace the named delimited block of a config file on the localhost, printing the changes as a unified diff
+// When dryRun is true, or the block already has the given contents, the file is not touched
+func applyLocalBlock(configFile string, blockName string, style *commentStyle, blockText string, dryRun bool) Exitcode {
+	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("config file %s does not exist\n", configFile)
+		return CONFIG_FILE_MISSING
+	}
+
+	original, updated, err := renderDelimitedBlock(configFile, blockName, style, blockText)
+	if err != nil {
+		fmt.Printf("unable to apply DTB to %s: %v\n", configFile, err)
+		if errors.Is(err, errUnterminatedBlock) {
+			return NEPH_CONFIG_ERROR
+		}
+		return FS_FAILURE
+	}
+
+	diff := unifiedDiff(configFile, original, updated)
+	if diff == "" {
+		fmt.Printf("%-10s %s\n", "no change", configFile)
+		return SUCCESS
+	}
+	fmt.Printf("%s", diff)
+
+	if dryRun {
+		fmt.Printf("%-10s %s\n", "dry run", configFile)
+		return SUCCESS
+	}
+
+	if err := replaceFileContents(configFile, []byte(updated)); err != nil {
+		fmt.Printf("unable to apply DTB to %s, the original is unchanged: %v\n", configFile, err)
+		return CONFIG_FILE_WRITE_FAILURE
+	}
+
+	fmt.Printf("%-10s %s\n", "applied", configFile)
 	return SUCCESS
 }
+
+// Send the DTB file to a private temporary directory on the remote host, then have the remote neph apply it
+func applyRemoteBlock(host string, configFile string, blockName string, style *commentStyle, dtbFile string, dryRun bool) Exitcode {
+	info, err := os.Stat(dtbFile)
+	if err != nil {
+		fmt.Printf("unable to stat DTB file %s: %v\n", dtbFile, err)
+		return FS_FAILURE
+	}
+
+	remote, exitCode := remoteFileSystem(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	// the DTB is staged in a private directory, so that no one else on the host can swap it before it is applied
+	tempDir, exitCode := createRemoteTempDir(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	defer removeRemoteTempDir(host, tempDir)
+
+	remoteDTB := path.Join(tempDir, filepath.Base(dtbFile))
+	err = copyFile(&localFS{}, dtbFile, remote, remoteDTB, info.ModTime(), NEPH_CONF_FILE_MODE)
+	if err != nil {
+		fmt.Printf("unable to copy %s to %s:%s: %v\n", dtbFile, host, remoteDTB, err)
+		return FS_FAILURE
+	}
+
+	nephCommand := fmt.Sprintf("neph apply localhost %s %s", shellQuote(configFile), shellQuote(remoteDTB))
+	nephCommand += remoteBlockOptions(blockName, style)
+	if dryRun {
+		nephCommand += " --dry-run"
+	}
+	return remoteNephCommand(host, nephCommand)
+}
@@ -0,0 +1,105 @@
+//=============================================================================
+// File:     comment-style.go
+// Contents: Comment syntax used by the NEPH block markers of each config file format
+//=============================================================================
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// The commentStyle type describes how a marker line is hidden from the program that reads the config file
+type commentStyle struct {
+	name   string // as given to the --comment-style option
+	prefix string // opens the comment
+	suffix string // closes the comment, or empty for comments that end with the line
+	fill   string // surrounds the marker text
+}
+
+// Every supported comment style; the first is the default for files with unrecognized extensions
+var commentStyles = []*commentStyle{
+	{"hash", "#", "", "-----"},          // shell, Python, YAML, most config files
+	{"semicolon", ";", "", "-----"},     // INI dialects
+	{"double-slash", "//", "", "-----"}, // C++, Java, JavaScript, Go
+	{"c-block", "/*", "*/", "-----"},    // C, CSS
+	{"xml", "<!--", "-->", " "},         // XML, HTML; "--" is not allowed within an XML comment
+	{"double-dash", "--", "", "-----"},  // SQL, Lua
+}
+
+// The comment style chosen for a config file with the given extension, when --comment-style isn't specified
+var extensionStyles = map[string]string{
+	".ini":   "semicolon",
+	".cpp":   "double-slash",
+	".hpp":   "double-slash",
+	".java":  "double-slash",
+	".js":    "double-slash",
+	".ts":    "double-slash",
+	".go":    "double-slash",
+	".c":     "c-block",
+	".h":     "c-block",
+	".css":   "c-block",
+	".xml":   "xml",
+	".html":  "xml",
+	".htm":   "xml",
+	".xhtml": "xml",
+	".svg":   "xml",
+	".sql":   "double-dash",
+	".lua":   "double-dash",
+}
+
+// Returns the comment style with the given name, or nil if there is no such style
+func findCommentStyle(name string) *commentStyle {
+	for _, style := range commentStyles {
+		if style.name == name {
+			return style
+		}
+	}
+	return nil
+}
+
+// Get the comment style requested with the --comment-style option
+// Returns nil if the option wasn't provided, meaning that the style should be chosen automatically
+func commentStyleOption(options []string) (*commentStyle, Exitcode) {
+	styleName := optionValue(options, "--comment-style", "")
+	if styleName == "" {
+		return nil, SUCCESS
+	}
+	style := findCommentStyle(styleName)
+	if style == nil {
+		fmt.Printf("unknown comment style '%s', use one of: %s\n", styleName, commentStyleNames())
+		return nil, CLI_BAD_ARGUMENTS
+	}
+	return style, SUCCESS
+}
+
+// Returns the comment style that suits the given config file, based on its extension
+func commentStyleForFile(targetFile string) *commentStyle {
+	ext := strings.ToLower(filepath.Ext(targetFile))
+	if name, ok := extensionStyles[ext]; ok {
+		return findCommentStyle(name)
+	}
+	return commentStyles[0]
+}
+
+// Returns the names of all supported comment styles, for use in messages
+func commentStyleNames() string {
+	var names []string
+	for _, style := range commentStyles {
+		names = append(names, style.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns the BEGIN and END marker lines for the named block
+func (style *commentStyle) markers(blockName string) (string, string) {
+	label := "NEPH"
+	if blockName != DEFAULT_BLOCK_NAME {
+		label += " " + blockName
+	}
+	begin := style.prefix + style.fill + "BEGIN " + label + style.fill + style.suffix
+	end := style.prefix + style.fill + "END " + label + style.fill + style.suffix
+	return begin, end
+}
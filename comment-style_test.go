@@ -0,0 +1,89 @@
+//=============================================================================
+// File:     comment-style_test.go
+// Contents: Tests of the comment syntax of NEPH block markers
+//=============================================================================
+
+package main
+
+import "testing"
+
+func TestMarkers(t *testing.T) {
+	tests := []struct {
+		style     string
+		blockName string
+		begin     string
+		end       string
+	}{
+		{"hash", "", "#-----BEGIN NEPH-----", "#-----END NEPH-----"},
+		{"hash", "tuning", "#-----BEGIN NEPH tuning-----", "#-----END NEPH tuning-----"},
+		{"semicolon", "", ";-----BEGIN NEPH-----", ";-----END NEPH-----"},
+		{"double-slash", "tuning", "//-----BEGIN NEPH tuning-----", "//-----END NEPH tuning-----"},
+		{"c-block", "", "/*-----BEGIN NEPH-----*/", "/*-----END NEPH-----*/"},
+		{"xml", "", "<!-- BEGIN NEPH -->", "<!-- END NEPH -->"},
+		{"xml", "tuning", "<!-- BEGIN NEPH tuning -->", "<!-- END NEPH tuning -->"},
+		{"double-dash", "", "-------BEGIN NEPH-----", "-------END NEPH-----"},
+	}
+	for _, test := range tests {
+		begin, end := findCommentStyle(test.style).markers(test.blockName)
+		if begin != test.begin || end != test.end {
+			t.Errorf("%s %q markers %q %q, want %q %q", test.style, test.blockName, begin, end, test.begin, test.end)
+		}
+	}
+}
+
+func TestMatchBeginMarker(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		blockName string
+		style     string // the style to look for, or empty for any style
+		want      string // the style matched, or empty for no match
+	}{
+		{"default block", "#-----BEGIN NEPH-----", "", "", "hash"},
+		{"indented", "\t  #-----BEGIN NEPH-----  ", "", "", "hash"},
+		{"c-block", "/*-----BEGIN NEPH-----*/", "", "", "c-block"},
+		{"xml", "<!-- BEGIN NEPH -->", "", "", "xml"},
+		{"double-dash", "-------BEGIN NEPH-----", "", "", "double-dash"},
+		{"named block", "//-----BEGIN NEPH tuning-----", "tuning", "", "double-slash"},
+		{"named marker isn't the default block", "#-----BEGIN NEPH tuning-----", "", "", ""},
+		{"default marker isn't a named block", "#-----BEGIN NEPH-----", "tuning", "", ""},
+		{"longer name", "#-----BEGIN NEPH tuning-2-----", "tuning", "", ""},
+		{"style given", ";-----BEGIN NEPH-----", "", "semicolon", "semicolon"},
+		{"other style than the one given", ";-----BEGIN NEPH-----", "", "hash", ""},
+		{"end marker", "#-----END NEPH-----", "", "", ""},
+		{"text", "BEGIN NEPH", "", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var style *commentStyle
+			if test.style != "" {
+				style = findCommentStyle(test.style)
+			}
+			matched := matchBeginMarker(test.line, test.blockName, style)
+			got := ""
+			if matched != nil {
+				got = matched.name
+			}
+			if got != test.want {
+				t.Errorf("matched %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommentStyleForFile(t *testing.T) {
+	tests := map[string]string{
+		"/etc/nginx/nginx.conf": "hash",
+		"/etc/php/php.INI":      "semicolon",
+		"/srv/app/config.js":    "double-slash",
+		"/srv/site/style.css":   "c-block",
+		"/srv/site/index.html":  "xml",
+		"/srv/db/schema.sql":    "double-dash",
+		"/etc/hosts":            "hash",
+	}
+	for file, want := range tests {
+		if got := commentStyleForFile(file).name; got != want {
+			t.Errorf("commentStyleForFile(%s) = %s, want %s", file, got, want)
+		}
+	}
+}
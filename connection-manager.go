@@ -0,0 +1,82 @@
+//=============================================================================
+// File:     connection-manager.go
+// Contents: Keep one SSH connection open to each host for the whole neph command
+//=============================================================================
+
+package main
+
+import (
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// The managedConnection type holds the SSH connection to a host, and the SFTP client running over it,
+// each opened the first time it is needed. Every session and SFTP transfer to the host shares them.
+type managedConnection struct {
+	dialOnce     sync.Once
+	clientConn   *ssh.Client
+	exitCode     Exitcode // the outcome of dialing, so that a failure is reported only once
+	sftpOnce     sync.Once
+	sftpClient   *sftp.Client
+	sftpExitCode Exitcode
+}
+
+// Every host contacted during this invocation, keyed by hostname
+var connections = make(map[string]*managedConnection)
+var connectionsMutex sync.Mutex
+
+// Get the entry for the host, creating an empty one if it hasn't been contacted yet
+func managedConnectionFor(hostname string) *managedConnection {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	mc, ok := connections[hostname]
+	if !ok {
+		mc = &managedConnection{}
+		connections[hostname] = mc
+	}
+	return mc
+}
+
+// Get the SSH connection to the host, dialing it on first use
+// The connection is shared, so the caller must not Close it; closeAllConnections does that when neph exits
+func hostConnection(hostname string) (*ssh.Client, Exitcode) {
+	mc := managedConnectionFor(hostname)
+	mc.dialOnce.Do(func() {
+		mc.clientConn, mc.exitCode = connectViaSSH(hostname)
+	})
+	return mc.clientConn, mc.exitCode
+}
+
+// Get the SFTP client for the host, starting the SFTP subsystem over its SSH connection on first use
+// The client is shared, so the caller must not Close it; closeAllConnections does that when neph exits
+func hostSFTP(hostname string) (*sftp.Client, Exitcode) {
+	clientConn, exitCode := hostConnection(hostname)
+	if exitCode != SUCCESS {
+		return nil, exitCode
+	}
+
+	mc := managedConnectionFor(hostname)
+	mc.sftpOnce.Do(func() {
+		mc.sftpClient, mc.sftpExitCode = openSFTP(clientConn)
+	})
+	return mc.sftpClient, mc.sftpExitCode
+}
+
+// Close every SFTP client and SSH connection opened during this invocation
+func closeAllConnections() {
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	for hostname, mc := range connections {
+		if mc.sftpClient != nil {
+			mc.sftpClient.Close()
+		}
+		if mc.clientConn != nil {
+			mc.clientConn.Close()
+		}
+		delete(connections, hostname)
+	}
+}
@@ -5,33 +5,95 @@
 
 package main
 
+import (
+	"os"
+	"time"
+)
+
 var NEPH_VERSION = "0.0.2"
 
 const (
-	SUCCESS                      Exitcode = iota // 0 = everything worked
-	FS_FAILURE                                   // 1 = general file system failure
-	BASH_SCRIPT_FAILED                           // 1 = typical failure code coming from a Bash script or executable, never explicilty used by Neph
-	SSH_LOCAL_CONFIGURATION_FAILURE              // 2 = SSH on the localhost not configured to be used by Neph
-	SSH_REMOTE_CONFIGURATION_FAILURE             // 3 = SSH on the remote host not setup to acept connections
-	SSH_CONNECTION_FAILURE                       // 4 = Connecting to remote host via SSH didn't succeed
-	SSH_SESSION_FAILURE                          // 5 = Connecting to remote host via SSH didn't succeed
-	NEPH_NOT_INITIALIZED                         // 6 = The neph executable was not found on the remote
-	NEPH_CONFIG_MISSING                          // 6 = A config in /etc/neph/conf doesn't exist
-	NEPH_CONFIG_ERROR                            // 7 = A config file is invalid
-	NEPH_SCRIPT_MISSING                          // 8 = A script in /var/neph/scripts doesn't exist
-	NEPH_SCRIPT_NOT_EXECUTABLE                   // 9 = A script in /var/neph/scripts isn't executable
-	NEPH_LOGIC_ERROR                             // 10 = Seemingly impossible to happen
-	CLI_BAD_ARGUMENTS                            // 11 = Arguments to the Neph CLI rejected
+	SUCCESS                          Exitcode = iota // 0 = everything worked
+	FS_FAILURE                                       // 1 = general file system failure
+	BASH_SCRIPT_FAILED                               // 2 = typical failure code coming from a Bash script or executable, never explicilty used by Neph
+	SSH_LOCAL_CONFIGURATION_FAILURE                  // 3 = SSH on the localhost not configured to be used by Neph
+	SSH_REMOTE_CONFIGURATION_FAILURE                 // 4 = SSH on the remote host not setup to acept connections
+	SSH_CONNECTION_FAILURE                           // 5 = Connecting to remote host via SSH didn't succeed
+	SSH_SESSION_FAILURE                              // 6 = Connecting to remote host via SSH didn't succeed
+	NEPH_NOT_INITIALIZED                             // 7 = The neph executable was not found on the remote
+	NEPH_CONFIG_MISSING                              // 8 = A config in /etc/neph/conf doesn't exist
+	NEPH_CONFIG_ERROR                                // 9 = A config file is invalid
+	NEPH_SCRIPT_MISSING                              // 10 = A script in /var/neph/scripts doesn't exist
+	NEPH_SCRIPT_NOT_EXECUTABLE                       // 11 = A script in /var/neph/scripts isn't executable
+	NEPH_LOGIC_ERROR                                 // 12 = Seemingly impossible to happen
+	CLI_BAD_ARGUMENTS                                // 13 = Arguments to the Neph CLI rejected
+	SYNC_PARTIAL_FAILURE                             // 14 = Some files were not copied, updated or deleted by push/pull
+	CONFIG_FILE_MISSING                              // 15 = The config file given to apply or examine doesn't exist
+	DTB_FILE_MISSING                                 // 16 = The DTB file given to apply doesn't exist
+	CONFIG_FILE_WRITE_FAILURE                        // 17 = The config file could not be rewritten with the new DTB
+	DTB_BLOCK_MISSING                                // 18 = The config file has no NEPH delimited block
+	HOST_NOT_FOUND                                   // 19 = The host isn't in the hostnames figtree, /etc/hosts or DNS
+	HOST_LIST_FAILURE                                // 20 = The command failed on some of the hosts in a list like nk024,nk025 or all
+	RUN_TIMED_OUT                                    // 21 = The exec script or remote neph command ran longer than --timeout
+	RUN_INTERRUPTED                                  // 22 = neph was stopped by SIGINT or SIGTERM while a script or command was running
+	INTERPRETER_INSTALL_FAILURE                      // 23 = The interpreter named by a local script's #! line is missing and couldn't be installed
 )
 
 const (
-	SSH_USER          string = "root"
-	SSH_IDENTITY_FILE string = "/root/.ssh/neph-rsa-private-key"
+	SSH_USER string = "root" // the user to login as, unless the host's entry in the hostnames figtree says otherwise
+	SSH_PORT string = "22"
 )
 
+// How many hosts of a list like nk024,nk025 or all are worked on at the same time, unless --parallel says otherwise
+const DEFAULT_PARALLELISM int = 4
+
+// How long to wait for a connection, how persistently to retry it, and how to notice when it has silently died
 const (
-	HOSTNAMES_CONF string = "/etc/neph/conf/hostnames"
+	SSH_CONNECT_TIMEOUT      time.Duration = 30 * time.Second // unless the host's connect-timeout setting or --connect-timeout says otherwise
+	SSH_CONNECT_RETRIES      int           = 3                // unless --retries says otherwise
+	SSH_RETRY_INITIAL_DELAY  time.Duration = 1 * time.Second  // doubled after every failed attempt
+	SSH_RETRY_MAX_DELAY      time.Duration = 30 * time.Second
+	SSH_KEEPALIVE_INTERVAL   time.Duration = 15 * time.Second
+	SSH_KEEPALIVE_MAX_MISSED int           = 3 // unanswered keepalives before the connection is given up on
 )
 
-type Exitcode uint
+// How long a script or remote neph command that was signaled to stop is given to exit,
+// before its session is closed, or its local process is killed
+const RUN_STOP_GRACE_PERIOD time.Duration = 5 * time.Second
+
+// The environment variables that locate ssh-agent and provide the passphrase of a protected private key
+const (
+	SSH_AUTH_SOCK_ENV        string = "SSH_AUTH_SOCK"
+	NEPH_PASSPHRASE_ENV      string = "NEPH_PASSPHRASE"
+	NEPH_PASSPHRASE_FILE_ENV string = "NEPH_PASSPHRASE_FILE"
+	NEPH_SUDO_PASSWORD_ENV   string = "NEPH_SUDO_PASSWORD"
+)
+
+// The private keys that neph may authenticate with, in order of preference
+// Each may be in OpenSSH or PEM format; the first one that exists is used
+var SSH_IDENTITY_FILES = []string{
+	"/root/.ssh/neph-ed25519-private-key",
+	"/root/.ssh/neph-ecdsa-private-key",
+	"/root/.ssh/neph-rsa-private-key",
+}
 
+const (
+	NEPH_EXECUTABLE      string = "/usr/bin/neph"
+	NEPH_CONF_DIR        string = "/etc/neph/conf"
+	NEPH_SCRIPTS_DIR     string = "/var/neph/scripts"
+	HOSTNAMES_CONF       string = "/etc/neph/conf/hostnames"
+	ETC_HOSTS            string = "/etc/hosts"
+	OS_RELEASE_FILE      string = "/etc/os-release"
+	KNOWN_HOSTS_FILE     string = "/etc/neph/known_hosts"
+	REMOTE_TEMP_TEMPLATE string = "/tmp/neph.XXXXXXXXXX"
+)
+
+const (
+	NEPH_EXECUTABLE_MODE  os.FileMode = 0700
+	NEPH_CONF_FILE_MODE   os.FileMode = 0600
+	NEPH_SCRIPT_FILE_MODE os.FileMode = 0700
+	NEPH_DIR_MODE         os.FileMode = 0700
+	SSH_IDENTITY_MODE     os.FileMode = 0600
+)
+
+type Exitcode uint
@@ -7,116 +7,202 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 )
 
-// Scan the given file looking for a NEPH delimited block.
-// Returns the text within the delimited block or an empty string if no such block exists
-func getDelimitedBlock(targetFile string) (string, error) {
+// A config file may hold several independently managed blocks, each identified by its name,
+// with markers like "#-----BEGIN NEPH nginx-tuning-----" and "#-----END NEPH nginx-tuning-----"
+// The unnamed block, which uses plain "#-----BEGIN NEPH-----" and "#-----END NEPH-----" markers, is the default block
+// The markers are written using the comment syntax of the config file, see comment-style.go
+const DEFAULT_BLOCK_NAME string = ""
+
+// Config file lines longer than this can't be scanned
+const MAX_CONFIG_LINE_LENGTH int = 1024 * 1024
+
+// The error for a BEGIN marker that no END marker follows, which leaves the extent of the block unknown
+var errUnterminatedBlock = errors.New("has no END marker")
+
+// Check the line to see if it is the BEGIN marker of the named block
+// When style is nil, markers written in any of the supported comment styles are recognized
+// Returns the comment style of the marker, or nil if the line isn't a BEGIN marker
+func matchBeginMarker(line string, blockName string, style *commentStyle) *commentStyle {
+	line = strings.TrimSpace(line)
+	for _, candidate := range commentStyles {
+		if style != nil && candidate != style {
+			continue
+		}
+		beginMarker, _ := candidate.markers(blockName)
+		if strings.HasPrefix(line, beginMarker) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// Returns true if the line is the END marker of the named block, written in the given comment style
+func matchEndMarker(line string, blockName string, style *commentStyle) bool {
+	_, endMarker := style.markers(blockName)
+	return strings.HasPrefix(strings.TrimSpace(line), endMarker)
+}
+
+// Returns true if the block name can be safely embedded in a marker line
+func isValidBlockName(blockName string) bool {
+	for _, r := range blockName {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return !strings.HasSuffix(blockName, "-") && !strings.Contains(blockName, "--")
+}
+
+// Scan the given file looking for the named NEPH delimited block.
+// When style is nil, markers written in any of the supported comment styles are recognized
+// When withMarkers is true, the BEGIN and END marker lines are included in the returned text
+// Returns the text within the delimited block and true, or an empty string and false if no such block exists
+// Returns errUnterminatedBlock if the block's BEGIN marker has no matching END marker
+func getDelimitedBlock(targetFile string, blockName string, style *commentStyle, withMarkers bool) (string, bool, error) {
 
 	if _, err := os.Stat(targetFile); errors.Is(err, os.ErrNotExist) {
 		fmt.Printf("getDelimitedBlock: no such file %s\n", targetFile)
-		return "", err
+		return "", false, err
 	}
 
 	inFile, err := os.Open(targetFile)
 	if err != nil {
 		fmt.Printf("getDelimitedBlock: can't open file %s\n", targetFile)
-		return "", err
+		return "", false, err
 	}
 	defer inFile.Close()
 
 	// create a scanner that uses the "ScanLines" splitter
 	scanner := bufio.NewScanner(inFile)
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_CONFIG_LINE_LENGTH)
 	scanner.Split(bufio.ScanLines)
 
 	var blockText = ""
+	var blockStyle *commentStyle
 	var bInsideBlock = false
+	var bBlockFound = false
+	var lineNumber, beginLine int
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNumber++
 
-		if strings.HasPrefix(line, "#-----BEGIN NEPH-----") {
+		if matched := matchBeginMarker(line, blockName, style); matched != nil && !bInsideBlock {
+			blockStyle = matched
 			bInsideBlock = true
-		} else if strings.HasPrefix(line, "#-----END NEPH-----") {
+			beginLine = lineNumber
+			bBlockFound = true
+			if withMarkers {
+				blockText += line + "\n"
+			}
+		} else if bInsideBlock && matchEndMarker(line, blockName, blockStyle) {
 			bInsideBlock = false
+			if withMarkers {
+				blockText += line + "\n"
+			}
 		} else if bInsideBlock {
 			blockText += line + "\n"
 		}
 	}
-	return blockText, nil
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("getDelimitedBlock: can't read file %s\n", targetFile)
+		return "", false, err
+	}
+	if bInsideBlock {
+		return "", false, fmt.Errorf("the NEPH block that begins on line %d %w", beginLine, errUnterminatedBlock)
+	}
+	return blockText, bBlockFound, nil
 }
 
-// Replace the given file's existing NEPH delimited block with the provided blockText
-// If the file doesn't have a NEPH delimited block, append a new block at the end of the file
-func replaceDelimitedBlock(targetFile string, blockText string) error {
+// Build the new contents of the given file, with its existing NEPH delimited block having the given name
+// replaced by the provided blockText. If the file doesn't have such a block, a new block is appended at the end.
+// Blocks with other names are left untouched. The file itself is not modified.
+// When style is nil, the markers keep the comment style of the existing block,
+// and a new block gets the comment style that suits the file's extension
+// Returns the original and the new contents of the file
+// Returns errUnterminatedBlock if the block's BEGIN marker has no matching END marker
+func renderDelimitedBlock(targetFile string, blockName string, style *commentStyle, blockText string) (string, string, error) {
 	if _, err := os.Stat(targetFile); errors.Is(err, os.ErrNotExist) {
-		fmt.Printf("replaceDelimitedBlock: no such file %s\n", targetFile)
-		return err
+		fmt.Printf("renderDelimitedBlock: no such file %s\n", targetFile)
+		return "", "", err
 	}
 
-	inFile, err := os.Open(targetFile)
+	original, err := ioutil.ReadFile(targetFile)
 	if err != nil {
-		fmt.Printf("replaceDelimitedBlock: can't open file for reading %s\n", targetFile)
-		return err
+		fmt.Printf("renderDelimitedBlock: can't read file %s\n", targetFile)
+		return "", "", err
 	}
-	defer inFile.Close()
 
-	tempFile := targetFile + ".tmp"
-	outFile, err := os.Create(tempFile)
-	if err != nil {
-		fmt.Printf("replaceDelimitedBlock: can't open tmp file for writing %s\n", tempFile)
-		return err
-	}
-	defer outFile.Close()
+	var writer strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(original))
+	scanner.Buffer(make([]byte, 0, 64*1024), MAX_CONFIG_LINE_LENGTH)
 
-	scanner := bufio.NewScanner(inFile)
-	writer := bufio.NewWriter(outFile)
+	// the END marker must always start on its own line
+	if blockText != "" && !strings.HasSuffix(blockText, "\n") {
+		blockText += "\n"
+	}
 
+	var blockStyle *commentStyle
 	var bInsideBlock = false
 	var bBlockWritten = false
+	var lineNumber, beginLine int
 	scanner.Split(bufio.ScanLines)
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNumber++
 
-		if strings.HasPrefix(line, "#-----BEGIN NEPH-----") {
+		if matched := matchBeginMarker(line, blockName, nil); matched != nil && !bInsideBlock {
+			blockStyle = matched
 			bInsideBlock = true
-			writer.WriteString("#-----BEGIN NEPH-----\n")
-			writer.WriteString(blockText)
-			writer.WriteString("#-----END NEPH-----\n")
-			bBlockWritten = true
-		} else if strings.HasPrefix(line, "#-----END NEPH-----") {
+			beginLine = lineNumber
+			if !bBlockWritten {
+				writeStyle := style
+				if writeStyle == nil {
+					writeStyle = matched
+				}
+				// keep the indentation of the existing markers
+				indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+				beginMarker, endMarker := writeStyle.markers(blockName)
+				writer.WriteString(indent + beginMarker + "\n")
+				writer.WriteString(blockText)
+				writer.WriteString(indent + endMarker + "\n")
+				bBlockWritten = true
+			}
+		} else if bInsideBlock && matchEndMarker(line, blockName, blockStyle) {
 			bInsideBlock = false
 		} else if !bInsideBlock {
 			writer.WriteString(line + "\n")
 		}
 	}
 
-	if !bBlockWritten {
-		writer.WriteString("#-----BEGIN NEPH-----\n")
-		writer.WriteString(blockText)
-		writer.WriteString("#-----END NEPH-----\n")
-		bBlockWritten = true
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("renderDelimitedBlock: can't read file %s\n", targetFile)
+		return "", "", err
+	}
+	// everything after the BEGIN marker would be replaced, so the file must not be written
+	if bInsideBlock {
+		return "", "", fmt.Errorf("the NEPH block that begins on line %d %w", beginLine, errUnterminatedBlock)
 	}
-	writer.Flush()
 
-	saveFile := targetFile + ".bak"
-	if _, err = os.Stat(saveFile); err == nil {
-		err = os.Remove(saveFile)
-		if err != nil {
-			fmt.Printf("replaceDelimitedBlock: unable to remove previous backup %s\n", saveFile)
+	if !bBlockWritten {
+		if style == nil {
+			style = commentStyleForFile(targetFile)
 		}
-	}
-	err = os.Rename(targetFile, saveFile)
-	if err != nil {
-		fmt.Printf("replaceDelimitedBlock: unable to save %s to %s\n", targetFile, saveFile)
-	}
-	err = os.Rename(tempFile, targetFile)
-	if err != nil {
-		fmt.Printf("replaceDelimitedBlock: unable to save %s to %s\n", tempFile, targetFile)
+		beginMarker, endMarker := style.markers(blockName)
+		writer.WriteString(beginMarker + "\n")
+		writer.WriteString(blockText)
+		writer.WriteString(endMarker + "\n")
+		bBlockWritten = true
 	}
 
-	return nil
+	return string(original), writer.String(), nil
 }
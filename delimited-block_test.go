@@ -0,0 +1,125 @@
+//=============================================================================
+// File:     delimited-block_test.go
+// Contents: Tests of reading and replacing NEPH delimited blocks
+//=============================================================================
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Write the contents to a file with the given name in a temporary directory, and return its path
+func writeTestFile(t *testing.T, name string, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRenderDelimitedBlock(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		original  string
+		blockName string
+		style     string
+		blockText string
+		want      string
+	}{
+		{"empty file", "app.conf", "", "", "", "a=1\n",
+			"#-----BEGIN NEPH-----\na=1\n#-----END NEPH-----\n"},
+		{"no trailing newline", "app.conf", "x=0", "", "", "a=1",
+			"x=0\n#-----BEGIN NEPH-----\na=1\n#-----END NEPH-----\n"},
+		{"replace default block", "app.conf", "x=0\n#-----BEGIN NEPH-----\nold\n#-----END NEPH-----\ny=0\n", "", "", "a=1\n",
+			"x=0\n#-----BEGIN NEPH-----\na=1\n#-----END NEPH-----\ny=0\n"},
+		{"keep indentation", "app.conf", "  #-----BEGIN NEPH-----\nold\n  #-----END NEPH-----\n", "", "", "a=1\n",
+			"  #-----BEGIN NEPH-----\na=1\n  #-----END NEPH-----\n"},
+		{"named block beside default block", "app.conf",
+			"#-----BEGIN NEPH-----\nd\n#-----END NEPH-----\n#-----BEGIN NEPH tuning-----\nold\n#-----END NEPH tuning-----\n",
+			"tuning", "", "a=1\n",
+			"#-----BEGIN NEPH-----\nd\n#-----END NEPH-----\n#-----BEGIN NEPH tuning-----\na=1\n#-----END NEPH tuning-----\n"},
+		{"default block beside named block", "app.conf",
+			"#-----BEGIN NEPH tuning-----\nt\n#-----END NEPH tuning-----\n", "", "", "a=1\n",
+			"#-----BEGIN NEPH tuning-----\nt\n#-----END NEPH tuning-----\n#-----BEGIN NEPH-----\na=1\n#-----END NEPH-----\n"},
+		{"style from extension", "site.ini", "", "", "", "a=1\n",
+			";-----BEGIN NEPH-----\na=1\n;-----END NEPH-----\n"},
+		{"xml markers", "page.html", "<p/>\n", "head", "", "<meta/>\n",
+			"<p/>\n<!-- BEGIN NEPH head -->\n<meta/>\n<!-- END NEPH head -->\n"},
+		{"existing style kept", "app.conf", "// BEGIN\n//-----BEGIN NEPH-----\nold\n//-----END NEPH-----\n", "", "", "a=1\n",
+			"// BEGIN\n//-----BEGIN NEPH-----\na=1\n//-----END NEPH-----\n"},
+		{"style option rewrites markers", "app.conf", "/*-----BEGIN NEPH-----*/\nold\n/*-----END NEPH-----*/\n", "", "hash", "a=1\n",
+			"#-----BEGIN NEPH-----\na=1\n#-----END NEPH-----\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeTestFile(t, test.file, test.original)
+			var style *commentStyle
+			if test.style != "" {
+				style = findCommentStyle(test.style)
+			}
+			original, updated, err := renderDelimitedBlock(path, test.blockName, style, test.blockText)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if original != test.original {
+				t.Errorf("original %q, want %q", original, test.original)
+			}
+			if updated != test.want {
+				t.Errorf("updated %q, want %q", updated, test.want)
+			}
+		})
+	}
+}
+
+func TestGetDelimitedBlock(t *testing.T) {
+	contents := "x=0\n" +
+		"#-----BEGIN NEPH-----\nd=1\n#-----END NEPH-----\n" +
+		"<!-- BEGIN NEPH head -->\n<meta/>\n<!-- END NEPH head -->\n"
+	tests := []struct {
+		name        string
+		blockName   string
+		style       string
+		withMarkers bool
+		want        string
+		found       bool
+	}{
+		{"default block", "", "", false, "d=1\n", true},
+		{"with markers", "", "", true, "#-----BEGIN NEPH-----\nd=1\n#-----END NEPH-----\n", true},
+		{"named block in another style", "head", "", false, "<meta/>\n", true},
+		{"named block in the wrong style", "head", "hash", false, "", false},
+		{"missing block", "tuning", "", false, "", false},
+	}
+	path := writeTestFile(t, "app.conf", contents)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var style *commentStyle
+			if test.style != "" {
+				style = findCommentStyle(test.style)
+			}
+			blockText, found, err := getDelimitedBlock(path, test.blockName, style, test.withMarkers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if blockText != test.want || found != test.found {
+				t.Errorf("got %q, %v, want %q, %v", blockText, found, test.want, test.found)
+			}
+		})
+	}
+}
+
+func TestUnterminatedBlock(t *testing.T) {
+	path := writeTestFile(t, "app.conf", "x=0\n#-----BEGIN NEPH-----\nold\ny=0\n")
+
+	if _, _, err := renderDelimitedBlock(path, "", nil, "a=1\n"); !errors.Is(err, errUnterminatedBlock) {
+		t.Errorf("renderDelimitedBlock error %v, want %v", err, errUnterminatedBlock)
+	}
+	if _, found, err := getDelimitedBlock(path, "", nil, false); !errors.Is(err, errUnterminatedBlock) || found {
+		t.Errorf("getDelimitedBlock %v, %v, want false, %v", found, err, errUnterminatedBlock)
+	}
+}
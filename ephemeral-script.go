@@ -0,0 +1,96 @@
+//=============================================================================
+// File:     ephemeral-script.go
+// Contents: Upload a local script to a remote host, execute it once, and remove it
+//=============================================================================
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Handle "neph exec host script --ephemeral"
+// The script is any local file, or else a script in the local /var/neph/scripts, and doesn't need to be
+// installed on the remote host. It is uploaded to a private directory that is removed once it has run.
+func executeEphemeralScript(host string, localScript string, args []string, env []string) Exitcode {
+	scriptPath, exitCode := findEphemeralScript(host, localScript)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	clientConn, exitCode := hostConnection(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	settings, exitCode := resolveTargetHost(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	remote, exitCode := remoteFileSystem(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	tempDir, exitCode := createRemoteTempDir(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	defer removeRemoteTempDir(host, tempDir)
+	remotePath := path.Join(tempDir, filepath.Base(scriptPath))
+
+	if exitCode := uploadEphemeralScript(remote, scriptPath, remotePath); exitCode != SUCCESS {
+		return exitCode
+	}
+	return doRemoteScript(clientConn, settings, localScript, remotePath, args, env)
+}
+
+// Find the local script to upload, which is a path to any file, or else the name of a script in /var/neph/scripts
+func findEphemeralScript(host string, localScript string) (string, Exitcode) {
+	candidates := []string{localScript, filepath.Join(NEPH_SCRIPTS_DIR, localScript)}
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err == nil && info.Mode().IsRegular() {
+			return candidate, SUCCESS
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			hostPrintf(host, "unable to read local script %s: %v\n", candidate, err)
+			return "", FS_FAILURE
+		}
+	}
+	hostPrintf(host, "local script %s does not exist, neither as a path nor in %s\n", localScript, NEPH_SCRIPTS_DIR)
+	return "", NEPH_SCRIPT_MISSING
+}
+
+// Copy the local script to the remote path, where only the user that executes it may read or execute it
+func uploadEphemeralScript(remote syncFS, scriptPath string, remotePath string) Exitcode {
+	local, err := os.Open(scriptPath)
+	if err != nil {
+		hostPrintf(remote.Name(), "unable to read local script %s: %v\n", scriptPath, err)
+		return FS_FAILURE
+	}
+	defer local.Close()
+
+	file, err := remote.Create(remotePath)
+	if err != nil {
+		hostPrintf(remote.Name(), "unable to create %s on %s: %v\n", remotePath, remote.Name(), err)
+		return FS_FAILURE
+	}
+	if _, err := io.Copy(file, local); err != nil {
+		file.Close()
+		hostPrintf(remote.Name(), "unable to upload %s to %s: %v\n", scriptPath, remote.Name(), err)
+		return FS_FAILURE
+	}
+	if err := file.Close(); err != nil {
+		hostPrintf(remote.Name(), "unable to upload %s to %s: %v\n", scriptPath, remote.Name(), err)
+		return FS_FAILURE
+	}
+	if err := remote.Chmod(remotePath, NEPH_SCRIPT_FILE_MODE); err != nil {
+		hostPrintf(remote.Name(), "unable to make %s executable on %s: %v\n", remotePath, remote.Name(), err)
+		return FS_FAILURE
+	}
+	return SUCCESS
+}
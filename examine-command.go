@@ -5,7 +5,68 @@
 
 package main
 
-// Handle "neph examine host configfile"
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Handle "neph examine host configfile [--block name] [--comment-style style] [--raw]"
 func commandExamine(host string, options []string) Exitcode {
+	args := positionalArgs(options)
+	if len(args) < 1 {
+		fmt.Printf("neph examine requires a configfile\n")
+		fmt.Printf("Try neph help\n")
+		return CLI_BAD_ARGUMENTS
+	}
+	configFile := args[0]
+	raw := hasOption(options, "--raw")
+
+	blockName := optionValue(options, "--block", DEFAULT_BLOCK_NAME)
+	if !isValidBlockName(blockName) {
+		fmt.Printf("invalid block name '%s', use only letters, digits, '.', '_' and '-'\n", blockName)
+		return CLI_BAD_ARGUMENTS
+	}
+	style, exitCode := commentStyleOption(options)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	if isLocalhost(host) {
+		return examineLocalBlock(configFile, blockName, style, raw)
+	}
+
+	nephCommand := fmt.Sprintf("neph examine localhost %s", shellQuote(configFile))
+	nephCommand += remoteBlockOptions(blockName, style)
+	if raw {
+		nephCommand += " --raw"
+	}
+	return remoteNephCommand(host, nephCommand)
+}
+
+// Print the named delimited block of a config file on the localhost
+func examineLocalBlock(configFile string, blockName string, style *commentStyle, raw bool) Exitcode {
+	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("config file %s does not exist\n", configFile)
+		return CONFIG_FILE_MISSING
+	}
+
+	blockText, found, err := getDelimitedBlock(configFile, blockName, style, raw)
+	if err != nil {
+		fmt.Printf("unable to examine %s: %v\n", configFile, err)
+		if errors.Is(err, errUnterminatedBlock) {
+			return NEPH_CONFIG_ERROR
+		}
+		return FS_FAILURE
+	}
+	if !found && blockName == DEFAULT_BLOCK_NAME {
+		fmt.Printf("config file %s has no NEPH block\n", configFile)
+		return DTB_BLOCK_MISSING
+	} else if !found {
+		fmt.Printf("config file %s has no NEPH block named '%s'\n", configFile, blockName)
+		return DTB_BLOCK_MISSING
+	}
+
+	fmt.Printf("%s", blockText)
 	return SUCCESS
 }
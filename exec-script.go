@@ -6,7 +6,6 @@
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"os"
@@ -18,76 +17,103 @@ import (
 
 // Handle the "neph exec" CLI
 func commandExecScript(host string, options []string) Exitcode {
+	request, exitCode := parseExecArgs(options)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	if request.ephemeral {
+		if isLocalhost(host) {
+			hostPrintf(host, "--ephemeral only applies to scripts executed on a remote host\n")
+			return CLI_BAD_ARGUMENTS
+		}
+		return executeEphemeralScript(host, request.script, request.args, request.env)
+	}
 
 	if isLocalhost(host) {
-		localScript := options[0]
-		return executeLocalScript(localScript, options[1:])
+		return executeLocalScript(request.script, request.args, request.env)
 	}
 
 	if isRemotehost(host) {
-		remoteScript := options[0]
-		return executeRemoteScript(host, remoteScript, options[1:])
+		return executeRemoteScript(host, request.script, request.args, request.env)
 	}
 
 	return NEPH_LOGIC_ERROR
 }
 
-// Execute a neph script on the localhost
-func executeLocalScript(localScript string, options []string) Exitcode {
+// Execute a neph script on the localhost, passing it the arguments and the variables in env, like "KEY=VALUE"
+func executeLocalScript(localScript string, args []string, env []string) Exitcode {
 	scriptPath := filepath.Join("/var/neph/scripts", localScript)
 	if _, err := os.Stat(scriptPath); errors.Is(err, os.ErrNotExist) {
-		fmt.Printf("local script %s does not exist", scriptPath)
+		hostPrintf("localhost", "local script %s does not exist", scriptPath)
 		return NEPH_SCRIPT_MISSING
 	}
+	if exitCode := ensureScriptInterpreter(scriptPath); exitCode != SUCCESS {
+		return exitCode
+	}
 
-	cmd := exec.Command(scriptPath)
+	cmd := exec.Command(scriptPath, args...)
+	cmd.Env = append(os.Environ(), env...)
+	stdout, stderr := newOutputStreams(outputLabel("localhost"))
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	fmt.Printf("\n--- Begin script %s ---\n", localScript)
-	defer fmt.Printf("\n--- End script %s ---\n", localScript)
+	hostPrintf("localhost", "\n--- Begin script %s ---\n", localScript)
+	defer hostPrintf("localhost", "\n--- End script %s ---\n", localScript)
 
-	out, err := cmd.Output()
-	fmt.Printf("%s", string(out))
+	err := runLocalCommand(cmd, "localhost")
+	stdout.Flush()
+	stderr.Flush()
 	if err != nil {
-		fmt.Printf("%v", err)
+		hostPrintf("localhost", "%v", err)
+		if exitCode := stoppedExitCode(err); exitCode != SUCCESS {
+			return exitCode
+		}
+		if cmd.ProcessState == nil {
+			return BASH_SCRIPT_FAILED
+		}
 		return Exitcode(cmd.ProcessState.ExitCode())
 	}
 	return SUCCESS
 }
 
-// execute a neph script on aremote  host
-func executeRemoteScript(host string, remoteScript string, options []string) Exitcode {
+// execute a neph script on aremote  host, passing it the arguments and the variables in env, like "KEY=VALUE"
+func executeRemoteScript(host string, remoteScript string, args []string, env []string) Exitcode {
 
-	clientConn, exitCode := connectViaSSH(host)
+	clientConn, exitCode := hostConnection(host)
 	if exitCode != SUCCESS {
 		return exitCode
 	}
-	defer clientConn.Close()
 
-	if !remoteScriptExists(clientConn, remoteScript) {
+	// the scripts are only accessible to root, which an unprivileged login user becomes through sudo
+	settings, exitCode := resolveTargetHost(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	if !remoteScriptExists(clientConn, settings, remoteScript) {
 		return NEPH_SCRIPT_MISSING
 	}
-	if !isRemoteScriptExecutable(clientConn, remoteScript) {
+	if !isRemoteScriptExecutable(clientConn, settings, remoteScript) {
 		return NEPH_SCRIPT_NOT_EXECUTABLE
 	}
 
-	return doRemoteScript(clientConn, remoteScript)
+	scriptPath := filepath.Join("/var/neph/scripts", remoteScript)
+	return doRemoteScript(clientConn, settings, remoteScript, scriptPath, args, env)
 }
 
 // Check to see if the script exists on the remote host
 // returns true if script exists
-func remoteScriptExists(clientConn *ssh.Client, remoteScript string) bool {
-	session, err := clientConn.NewSession()
-	if err != nil {
-		fmt.Printf("failed to create session: %v\n", err)
-		return false
-	}
-	defer session.Close()
-
+func remoteScriptExists(clientConn *ssh.Client, settings *Host, remoteScript string) bool {
 	scriptPath := filepath.Join("/var/neph/scripts", remoteScript)
 	testCommand := fmt.Sprintf("test -f %s", scriptPath)
-	err = session.Run(testCommand)
+	err := runPrivileged(clientConn, settings, testCommand, nil)
+	if _, ok := err.(*ssh.ExitError); err != nil && !ok {
+		hostPrintf(settings.hostname, "unable to look for remote script %s: %v\n", scriptPath, err)
+		return false
+	}
 	if err != nil {
-		fmt.Printf("remote script %s does not exist\n", scriptPath)
+		hostPrintf(settings.hostname, "remote script %s does not exist\n", scriptPath)
 		return false
 	} else {
 		return true
@@ -96,48 +122,58 @@ func remoteScriptExists(clientConn *ssh.Client, remoteScript string) bool {
 
 // Check to see if the script on the remote host is executable
 // returns true if script is executable
-func isRemoteScriptExecutable(clientConn *ssh.Client, remoteScript string) bool {
-	session, err := clientConn.NewSession()
-	if err != nil {
-		fmt.Printf("failed to create session: %v\n", err)
-		return false
-	}
-	defer session.Close()
-
+func isRemoteScriptExecutable(clientConn *ssh.Client, settings *Host, remoteScript string) bool {
 	scriptPath := filepath.Join("/var/neph/scripts", remoteScript)
 	testCommand := fmt.Sprintf("test -x %s", scriptPath)
-	err = session.Run(testCommand)
+	err := runPrivileged(clientConn, settings, testCommand, nil)
 	if err != nil {
-		fmt.Printf("remote script is not executable. Try 'chmod +x %s'\n", scriptPath)
+		hostPrintf(settings.hostname, "remote script is not executable. Try 'chmod +x %s'\n", scriptPath)
 		return false
 	} else {
 		return true
 	}
 }
 
-// Run the remote script, capture its output, return its exitCode
-// Returns true if the script was executed and returned 0
-func doRemoteScript(clientConn *ssh.Client, remoteScript string) Exitcode {
+// Run the remote script found at scriptPath, capture its output, return its exitCode
+// Returns SUCCESS if the script was executed and returned 0
+func doRemoteScript(clientConn *ssh.Client, settings *Host, remoteScript string, scriptPath string, args []string, env []string) Exitcode {
+	command := remoteScriptCommand(scriptPath, args)
+
 	session, err := clientConn.NewSession()
 	if err != nil {
-		fmt.Printf("failed to create session: %v\n", err)
+		hostPrintf(settings.hostname, "failed to create session: %v\n", err)
 		return SSH_SESSION_FAILURE
 	}
 	defer session.Close()
 
-	fmt.Printf("--- Begin remote script %s ---\n", remoteScript)
-	defer fmt.Printf("--- End remote script %s ---\n", remoteScript)
+	// The variables are set by the server when it allows that, otherwise they are exported by the command itself.
+	// sudo clears the environment, so when it is used the variables must always be exported within it.
+	if len(env) > 0 && (needsSudo(settings) || !setSessionEnv(session, env)) {
+		if verbose && !needsSudo(settings) {
+			hostPrintf(settings.hostname, "%s doesn't accept the variables, exporting them in the command instead\n", settings.hostname)
+		}
+		command = envExportPrefix(env) + command
+	}
 
-	var b bytes.Buffer
-	session.Stdout = &b
-	scriptPath := filepath.Join("/var/neph/scripts", remoteScript)
-	err = session.Run(scriptPath)
-	fmt.Printf("%s", b.String())
+	command, stdin, err := privilegedCommand(settings, command)
+	if err != nil {
+		hostPrintf(settings.hostname, "%v\n", err)
+		return SSH_LOCAL_CONFIGURATION_FAILURE
+	}
+
+	hostPrintf(settings.hostname, "--- Begin remote script %s ---\n", remoteScript)
+	defer hostPrintf(settings.hostname, "--- End remote script %s ---\n", remoteScript)
+
+	stdout, stderr := newOutputStreams(outputLabel(settings.hostname))
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+	err = runSession(session, command, settings.hostname)
+	stdout.Flush()
+	stderr.Flush()
 	if err != nil {
-		fmt.Printf("%s didn't exit cleanly: %v\n", scriptPath, err)
-		ee := err.(*ssh.ExitError)
-		rc := Exitcode(ee.Waitmsg.ExitStatus())
-		return rc
+		hostPrintf(settings.hostname, "%s didn't exit cleanly: %v\n", scriptPath, err)
+		return remoteExitCode(err)
 	}
 	return SUCCESS
 }
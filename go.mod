@@ -14,5 +14,6 @@ require (
 	github.com/readwritepro/compare-test-results v0.0.0-00010101000000-000000000000
 	github.com/readwritepro/error-handler v0.0.0-00010101000000-000000000000
 	github.com/readwritepro/figtree v0.0.0-00010101000000-000000000000
-	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e // indirect
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
 )
@@ -14,10 +14,11 @@ using passwordless SSH with root privileges.
 
 Usage 1) neph [init|push|pull|scrub] host
 Usage 2) neph info [configs|scripts|hosts] [host|localhost]
-Usage 3) neph apply [host|localhost] configfile dtbfile
-Usage 4) neph examine [host|localhost] configfile
-Usage 5) neph exec [host|localhost] script
-Usage 6) neph [version|help]
+Usage 3) neph apply [host|localhost] configfile dtbfile [--block name]
+Usage 4) neph examine [host|localhost] configfile [--block name]
+Usage 5) neph exec [host|host,host...|all|localhost] script [args...] [-- args...]
+Usage 6) neph hostkey [show|forget|accept] host
+Usage 7) neph [version|help]
 
     init          copy the neph executable, scripts, and figtree files from the local host to the remote host
                   neph init host [--privileged]
@@ -29,7 +30,7 @@ Usage 6) neph [version|help]
                   neph pull host [--force]
 
     scrub         remove figtree files (from this device) that were used by a former remote host
-                  neph scrub host	
+                  neph scrub host [--yes]
 
     info hosts    list the hostnames and IP addresses known by the specified host
                   neph info hosts [host]
@@ -41,24 +42,68 @@ Usage 6) neph [version|help]
                   neph info scripts [host]
 
     apply         apply a DTB (delimited text block) to a config file
-                  neph apply host configfile dtbfile
+                  neph apply host configfile dtbfile [--block name] [--comment-style style] [--dry-run]
 
     examine       examine a config file and print the contents of its DTB (delimited text block)
-                  neph examine host configfile
+                  neph examine host configfile [--block name] [--comment-style style] [--raw]
+
+    hostkey       manage the host keys in /etc/neph/known_hosts, which are recorded on first contact
+                  neph hostkey show host     list the host key recorded for the host
+                  neph hostkey forget host   remove it, so that the next connection records a new one
+                  neph hostkey accept host   replace it with the key that the host presents now
 
     exec          execute the specified script on the local or remote host
-                  neph localhost script-file
-                  neph remotehost script-file
+                  neph exec localhost script-file [args...] [--env KEY=VALUE] [--env-file file] [-- args...]
+                  neph exec remotehost script-file [args...] [--env KEY=VALUE] [--env-file file] [-- args...]
+                  neph exec remotehost ./local-script [args...] --ephemeral   upload, execute, then remove a local script
+                  neph exec nk024,nk025,nk026 script-file [--parallel 4]      execute on several hosts at the same time
+                  neph exec all script-file [--parallel 4]                    execute on every host in the hostnames figtree
 
 Options:
-    --force      copy, update, and delete scripts and configurations without checking timestamps 
-    --privileged elevates the target host to be a privileged device by sending it the private ssh key
+    --force            copy, update, and delete scripts and configurations without checking timestamps 
+    --privileged       elevates the target host to be a privileged device by sending it the private ssh key
+    --yes              remove files without asking for confirmation
+    --dry-run          show the changes that apply would make to a config file, without making them
+    --raw              include the BEGIN and END marker lines when examining a DTB
+    --via              the jump host to tunnel through to reach the remote host, overriding its via setting
+    --user             the user to login as, overriding the host's user setting; any user but root gains root
+                       privileges through sudo
+    --connect-timeout  seconds (or a duration like 1m30s) to wait for each connection, overriding connect-timeout
+    --retries          how many times to retry a connection that failed for a transient reason (default 3)
+    --timeout          seconds (or a duration like 1m30s) an exec script or remote neph command may run before it is
+                       stopped (exit code 21); Ctrl-C or SIGTERM is passed on to it as well (exit code 22)
+    --env              set an environment variable for an exec script, like --env KEY=VALUE (may be repeated)
+    --env-file         set the environment variables in a file of KEY=VALUE lines for an exec script
+    --                 pass every argument that follows to the exec script, even ones that look like options
+    --ephemeral        upload a local script to a private temporary directory on the remote host, execute it, and remove it
+    --parallel         how many hosts of a list like nk024,nk025 or all to execute on at the same time (default 4);
+                       each line of output is labeled with its host, and a table of exit codes and durations follows
+    --prefix           label each line of a script's output with its host, as [host] or [host stderr]
+    --no-install       never install the interpreter a local exec script's #! line names when it is missing; by default
+                       it is installed with the package manager (dnf, yum, apt-get, apk, zypper) (exit code 23 if it fails)
+    --verbose          report where each host's address was found: the hostnames figtree, /etc/hosts or DNS
+    --block            the name of the DTB to apply or examine, when a config file holds more than one
+    --comment-style    the comment syntax of the DTB markers: hash, semicolon, double-slash, c-block, xml, double-dash
+                       (chosen from the config file's extension when not specified)
 
 File Locations:
     /usr/bin/neph                    CLI executable (chmod 700)
     /etc/neph/conf                   figtree configuration files (chmod 600)
-    /root/.ssh/neph-rsa-private-key  PEM formatted SSH key (chmod 600)
+    /etc/neph/conf/hostnames         remote hosts, each with an address or a branch of connection settings:
+                                     address, port, user, identity-file, connect-timeout, via, sudo
+    /etc/neph/known_hosts            host keys of remote hosts, recorded on first contact (chmod 600)
+    /root/.ssh/neph-*-private-key    SSH key in OpenSSH or PEM format (chmod 600), the first found of
+                                     neph-ed25519-private-key, neph-ecdsa-private-key, neph-rsa-private-key
     /var/neph/scripts                script files (chmod 700)
 
+Authentication:
+    The keys held by ssh-agent are offered first, when SSH_AUTH_SOCK is set, followed by the private key file.
+    A passphrase protected private key is only decrypted if the remote host accepts it, using the passphrase in
+    NEPH_PASSPHRASE, or else in the file named by NEPH_PASSPHRASE_FILE, or else by prompting for it.
+    A user other than root runs every remote command and file write through sudo, which must not ask for a
+    password, unless the host's sudo setting is "password", in which case the password is taken from
+    NEPH_SUDO_PASSWORD, or else by prompting for it. Files are staged in the user's home directory and
+    then installed by sudo.
+
 `)
 }
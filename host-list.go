@@ -0,0 +1,170 @@
+//=============================================================================
+// File:     host-list.go
+// Contents: Run a command on several hosts at the same time, like nk024,nk025,nk026 or all
+//=============================================================================
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The host list that stands for every host in the hostnames figtree
+const ALL_HOSTS string = "all"
+
+// The outcome of running the command on one host of the list
+type hostResult struct {
+	host     string
+	exitCode Exitcode
+	duration time.Duration
+}
+
+// Returns true if the argument is a list of hosts separated by commas, or all
+// The hosts themselves are resolved when the list is expanded, so that an unknown one can be reported by name
+func isHostList(argv string) bool {
+	if argv == ALL_HOSTS {
+		return true
+	}
+	return !strings.HasPrefix(argv, "-") && !strings.Contains(argv, "=") && strings.Contains(argv, ",")
+}
+
+// Handle a command given a list of hosts instead of a single host
+// Only neph exec accepts a list; the output of every host is prefixed with its name, and a summary follows
+// Returns SUCCESS if the command succeeded on every host, otherwise HOST_LIST_FAILURE
+func executeHostListCommand(command string, hostList string, options []string) Exitcode {
+	if command != "exec" {
+		fmt.Printf("neph %s takes a single host, only neph exec accepts a list like '%s'\n", command, hostList)
+		return CLI_BAD_ARGUMENTS
+	}
+
+	hosts, exitCode := expandHostList(hostList)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	parallelism, exitCode := parseParallelism(beforeDoubleDash(options))
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	// a mistake in the arguments is reported once, rather than once for every host
+	if _, exitCode := parseExecArgs(options); exitCode != SUCCESS {
+		return exitCode
+	}
+
+	prefixOutput = true
+	results := runOnHosts(hosts, parallelism, func(host string) Exitcode {
+		return commandExecScript(host, options)
+	})
+	exitCode = printHostListSummary(results)
+	if exitCode != SUCCESS && isInterrupted() {
+		return RUN_INTERRUPTED
+	}
+	return exitCode
+}
+
+// Get the hosts named by the list, in the order given, or sorted when the list is all
+func expandHostList(hostList string) ([]string, Exitcode) {
+	if hostList == ALL_HOSTS {
+		configured, exitCode := GetAllHostnames()
+		if exitCode != SUCCESS {
+			return nil, exitCode
+		}
+		if len(configured) == 0 {
+			fmt.Printf("there are no hosts in %s\n", HOSTNAMES_CONF)
+			return nil, HOST_NOT_FOUND
+		}
+		var hosts []string
+		for hostname := range configured {
+			hosts = append(hosts, hostname)
+		}
+		sort.Strings(hosts)
+		return hosts, SUCCESS
+	}
+
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, host := range strings.Split(hostList, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" || seen[host] {
+			continue
+		}
+		if !isLocalhost(host) && !isRemotehost(host) {
+			fmt.Printf("unable to resolve %s using %s, %s or DNS\n", host, HOSTNAMES_CONF, ETC_HOSTS)
+			return nil, HOST_NOT_FOUND
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	return hosts, SUCCESS
+}
+
+// Get the number of hosts to work on at the same time, from --parallel
+func parseParallelism(options []string) (int, Exitcode) {
+	value := optionValue(options, "--parallel", "")
+	if value == "" {
+		return DEFAULT_PARALLELISM, SUCCESS
+	}
+	parallelism, err := strconv.Atoi(value)
+	if err != nil || parallelism < 1 {
+		fmt.Printf("--parallel '%s' is not a number of hosts\n", value)
+		return 0, CLI_BAD_ARGUMENTS
+	}
+	return parallelism, SUCCESS
+}
+
+// Run the function for every host, with no more than parallelism of them running at the same time
+// Returns the results in the same order as the hosts
+func runOnHosts(hosts []string, parallelism int, run func(host string) Exitcode) []hostResult {
+	results := make([]hostResult, len(hosts))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			// hosts still waiting for a slot when neph is interrupted aren't started at all
+			if isInterrupted() {
+				results[i] = hostResult{host, RUN_INTERRUPTED, 0}
+				return
+			}
+			start := time.Now()
+			exitCode := run(host)
+			results[i] = hostResult{host, exitCode, time.Since(start)}
+		}(i, host)
+	}
+	wg.Wait()
+	return results
+}
+
+// Print a table of every host's exit code and how long it took
+// Returns SUCCESS if every host succeeded, otherwise HOST_LIST_FAILURE
+func printHostListSummary(results []hostResult) Exitcode {
+	width := len("host")
+	for _, result := range results {
+		if len(result.host) > width {
+			width = len(result.host)
+		}
+	}
+
+	failed := 0
+	fmt.Printf("\n%-*s  %-9s  %s\n", width, "host", "exit code", "duration")
+	for _, result := range results {
+		fmt.Printf("%-*s  %-9d  %s\n", width, result.host, result.exitCode, result.duration.Round(time.Millisecond))
+		if result.exitCode != SUCCESS {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("failed on %d of %d hosts\n", failed, len(results))
+		return HOST_LIST_FAILURE
+	}
+	return SUCCESS
+}
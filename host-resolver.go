@@ -0,0 +1,153 @@
+//=============================================================================
+// File:     host-resolver.go
+// Contents: Find the address and connection settings of a host given on the command line
+//=============================================================================
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The sources that a host's address may come from, in the order they are consulted
+const (
+	SOURCE_HOSTNAMES string = HOSTNAMES_CONF
+	SOURCE_ETC_HOSTS string = ETC_HOSTS
+	SOURCE_DNS       string = "DNS"
+)
+
+// True when --verbose was given on the command line
+var verbose bool
+
+// The user given with --user, which overrides the user setting of the host given on the command line
+var userOption string
+
+// Every host resolved so far, so that each is only looked up once per invocation
+// A nil entry means the host could not be resolved
+var resolvedHosts = make(map[string]*Host)
+var resolvedHostsMutex sync.Mutex
+
+// The hosts listed in the hostnames figtree, read the first time they are needed,
+// and the outcome of reading it, so that a broken figtree is only reported once
+var figtreeHosts map[string]*Host
+var figtreeHostsExitCode Exitcode
+
+// Find the connection settings of the given host
+// The hostnames figtree is consulted first, then /etc/hosts, then DNS
+// Hosts found in /etc/hosts or DNS are connected to with the default settings
+func resolveHost(hostname string) (*Host, Exitcode) {
+	resolvedHostsMutex.Lock()
+	defer resolvedHostsMutex.Unlock()
+
+	if host, ok := resolvedHosts[hostname]; ok {
+		if host == nil {
+			return nil, HOST_NOT_FOUND
+		}
+		return host, SUCCESS
+	}
+
+	host, exitCode := lookupHost(hostname)
+	if exitCode != SUCCESS {
+		resolvedHosts[hostname] = nil
+		return nil, exitCode
+	}
+	resolvedHosts[hostname] = host
+
+	if verbose {
+		hostPrintf(hostname, "resolved %s to %s using %s\n", hostname, host.address, host.source)
+	}
+	return host, SUCCESS
+}
+
+// Find the connection settings of the host given on the command line, which --user applies to
+// Jump hosts are resolved with resolveHost, keeping their own user setting
+func resolveTargetHost(hostname string) (*Host, Exitcode) {
+	host, exitCode := resolveHost(hostname)
+	if exitCode != SUCCESS || userOption == "" {
+		return host, exitCode
+	}
+	target := *host
+	target.user = userOption
+	return &target, SUCCESS
+}
+
+// Consult each source in turn for the given host
+func lookupHost(hostname string) (*Host, Exitcode) {
+	host, exitCode := lookupHostnamesConf(hostname)
+	if exitCode != SUCCESS || host != nil {
+		return host, exitCode
+	}
+
+	if address := lookupEtcHosts(hostname); address != "" {
+		host := newHost(hostname, address)
+		host.source = SOURCE_ETC_HOSTS
+		return host, SUCCESS
+	}
+
+	if addresses, err := net.LookupHost(hostname); err == nil && len(addresses) > 0 {
+		host := newHost(hostname, addresses[0])
+		host.source = SOURCE_DNS
+		return host, SUCCESS
+	}
+
+	return nil, HOST_NOT_FOUND
+}
+
+// Look for the host in the hostnames figtree
+// Returns nil if the figtree doesn't exist or doesn't list the host
+func lookupHostnamesConf(hostname string) (*Host, Exitcode) {
+	if figtreeHosts == nil {
+		if _, err := os.Stat(HOSTNAMES_CONF); os.IsNotExist(err) {
+			figtreeHosts = make(map[string]*Host)
+		} else {
+			figtreeHosts, figtreeHostsExitCode = GetAllHostnames()
+		}
+	}
+	if figtreeHostsExitCode != SUCCESS {
+		return nil, figtreeHostsExitCode
+	}
+
+	host, ok := figtreeHosts[hostname]
+	if !ok {
+		return nil, SUCCESS
+	}
+	return host, SUCCESS
+}
+
+// Look for the host in /etc/hosts, whose lines are "address name [aliases...]"
+// Returns the first address listed for the host, or an empty string if there is none
+func lookupEtcHosts(hostname string) string {
+	file, err := os.Open(ETC_HOSTS)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if strings.EqualFold(name, hostname) {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+// Returns true if the address is this device's loopback address, like 127.0.0.1 or ::1
+func isLoopbackAddress(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,92 @@
+//=============================================================================
+// File:     hostkey-command.go
+// Contents: Manage the host keys recorded in /etc/neph/known_hosts
+//=============================================================================
+
+package main
+
+import "fmt"
+
+// Handle "neph hostkey show host"
+// List the host keys recorded for the host
+func commandHostkeyShow(hostname string, options []string) Exitcode {
+	host, exitCode := hostkeyTarget(hostname, options)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	knownKeys, err := knownHostKeys(host.knownHostsAddress())
+	if err != nil {
+		fmt.Printf("unable to read %s: %v\n", KNOWN_HOSTS_FILE, err)
+		return FS_FAILURE
+	}
+	if len(knownKeys) == 0 {
+		fmt.Printf("no host key recorded for %s\n", host.hostname)
+		return SUCCESS
+	}
+	for _, knownKey := range knownKeys {
+		fmt.Printf("%s %s\n", host.hostname, describeHostKey(knownKey))
+	}
+	return SUCCESS
+}
+
+// Handle "neph hostkey forget host"
+// Remove the host keys recorded for the host, so that the next connection records a new one
+func commandHostkeyForget(hostname string, options []string) Exitcode {
+	host, exitCode := hostkeyTarget(hostname, options)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	removed, err := forgetKnownHostKeys(host.knownHostsAddress())
+	if err != nil {
+		fmt.Printf("unable to update %s: %v\n", KNOWN_HOSTS_FILE, err)
+		return FS_FAILURE
+	}
+	fmt.Printf("forgot %d host key(s) for %s\n", removed, host.hostname)
+	return SUCCESS
+}
+
+// Handle "neph hostkey accept host"
+// Replace the host keys recorded for the host with the key it presents now
+func commandHostkeyAccept(hostname string, options []string) Exitcode {
+	host, exitCode := hostkeyTarget(hostname, options)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	hostKey, exitCode := fetchHostKey(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	if _, err := forgetKnownHostKeys(host.knownHostsAddress()); err != nil {
+		fmt.Printf("unable to update %s: %v\n", KNOWN_HOSTS_FILE, err)
+		return FS_FAILURE
+	}
+	if err := addKnownHostKey(host.knownHostsAddress(), hostKey); err != nil {
+		fmt.Printf("unable to update %s: %v\n", KNOWN_HOSTS_FILE, err)
+		return FS_FAILURE
+	}
+	fmt.Printf("accepted %s %s\n", host.hostname, describeHostKey(hostKey))
+	return SUCCESS
+}
+
+// The host whose key is being managed, with the port that its entry in the hostnames figtree specifies
+// A host that can't be resolved (anymore) is passed along as the first option
+func hostkeyTarget(hostname string, options []string) (*Host, Exitcode) {
+	if isLocalhost(hostname) && len(options) > 0 && !isOption(options[0]) {
+		hostname = options[0]
+	}
+	if isLocalhost(hostname) {
+		fmt.Printf("neph hostkey requires the name of a remote host\n")
+		return nil, CLI_BAD_ARGUMENTS
+	}
+
+	// the keys of a host that no longer resolves can still be shown and forgotten
+	host, exitCode := resolveHost(hostname)
+	if exitCode == HOST_NOT_FOUND {
+		return newHost(hostname, hostname), SUCCESS
+	}
+	return host, exitCode
+}
@@ -4,12 +4,31 @@
 //=============================================================================
 
 /*
+Each host is either a flat "name address" entry, or a branch holding its connection settings,
+all of which are optional except for the address
+
 hostnames {
     nk024       165.227.3.8
     nk025       165.227.11.3
     nk026       138.68.26.133
     nk027       178.128.74.100
     nk028       167.99.98.215
+    nk029 {
+        address          10.116.0.4
+        port             2222
+        user             admin
+        identity-file    /root/.ssh/nk029-private-key
+        connect-timeout  10
+    }
+    nk030 {
+        address          10.116.0.5
+        via              nk029
+    }
+    nk031 {
+        address          10.116.0.6
+        user             deploy
+        sudo             password
+    }
 }
 */
 
@@ -17,31 +36,55 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/readwritepro/figtree"
 )
 
-// Get the IPv4 address of the given hostname by reading it from /etc/neph/conf/hostnames
-func GetHostname(hostname string) (string, Exitcode) {
-	root, err := figtree.ReadConfig(HOSTNAMES_CONF)
-	if err != nil {
-		fmt.Printf("unable to read hostnames configuration from %s\n", HOSTNAMES_CONF)
-		return "", NEPH_CONFIG_MISSING
-	}
+// The Host type holds the settings used to connect to a host
+type Host struct {
+	hostname       string        // the name used on the command line, like "nk024"
+	address        string        // IP address or DNS name to dial
+	port           string        // SSH port, like "22"
+	user           string        // the user to login as
+	identityFile   string        // the private key to authenticate with, or empty to use the first of SSH_IDENTITY_FILES
+	connectTimeout time.Duration // the longest to wait for the connection to be established, or 0 to wait indefinitely
+	via            string        // the jump host to tunnel through, or empty to connect directly
+	sudo           string        // how sudo gets the password, when user isn't root: SUDO_NOPASSWD or SUDO_PASSWORD
+	source         string        // where the address was found: the hostnames figtree, /etc/hosts or DNS
+}
 
-	key := fmt.Sprintf("/hostnames/%s", hostname)
-	ipAddress, err := root.GetValue(key)
-	if err != nil {
-		fmt.Printf("hostname '%s' not listed in %s configuration\n", hostname, HOSTNAMES_CONF)
-		return "", NEPH_CONFIG_MISSING
+// Create a host record with the default connection settings
+func newHost(hostname string, address string) *Host {
+	return &Host{
+		hostname:       hostname,
+		address:        address,
+		port:           SSH_PORT,
+		user:           SSH_USER,
+		connectTimeout: SSH_CONNECT_TIMEOUT,
+		sudo:           SUDO_NOPASSWD,
+		source:         SOURCE_HOSTNAMES,
 	}
+}
+
+// The address to dial, like "165.227.3.8:22"
+func (h *Host) dialAddress() string {
+	return net.JoinHostPort(h.address, h.port)
+}
 
-	return ipAddress, SUCCESS
+// The address that the host key is recorded under in the known_hosts file, like "nk024:22"
+func (h *Host) knownHostsAddress() string {
+	return net.JoinHostPort(h.hostname, h.port)
 }
 
-// Get a map of all configured hosts => IP address
-func GetAllHostnames() (map[string]string, Exitcode) {
-	configuredHosts := make(map[string]string)
+// Get a map of all configured hostnames => connection settings
+func GetAllHostnames() (map[string]*Host, Exitcode) {
+	configuredHosts := make(map[string]*Host)
 
 	root, err := figtree.ReadConfig(HOSTNAMES_CONF)
 	if err != nil {
@@ -58,11 +101,145 @@ func GetAllHostnames() (map[string]string, Exitcode) {
 	hostnamesBranch, _ := hostnamesItem.Branch()
 	for _, item := range hostnamesBranch.Items {
 		hostname := item.Key()
-		ipAddress, err := item.Value()
-		if err == nil {
-			configuredHosts[hostname] = ipAddress
+
+		// the flat "name address" form
+		if address, err := item.Value(); err == nil {
+			configuredHosts[hostname] = newHost(hostname, address)
+			continue
+		}
+
+		settingsBranch, err := item.Branch()
+		if err != nil {
+			fmt.Printf("hostname '%s' in %s is neither an address nor a branch of settings\n", hostname, HOSTNAMES_CONF)
+			return configuredHosts, NEPH_CONFIG_ERROR
+		}
+		host, err := parseHostSettings(hostname, settingsBranch)
+		if err != nil {
+			fmt.Printf("hostname '%s' in %s: %v\n", hostname, HOSTNAMES_CONF, err)
+			return configuredHosts, NEPH_CONFIG_ERROR
 		}
+		configuredHosts[hostname] = host
 	}
 
 	return configuredHosts, SUCCESS
 }
+
+// Read the connection settings from a host's branch of the hostnames figtree
+func parseHostSettings(hostname string, settingsBranch *figtree.Branch) (*Host, error) {
+	host := newHost(hostname, "")
+
+	for _, item := range settingsBranch.Items {
+		value, err := item.Value()
+		if err != nil {
+			return nil, fmt.Errorf("'%s' must have a value", item.Key())
+		}
+
+		switch item.Key() {
+		case "address":
+			host.address = value
+		case "port":
+			if port, err := strconv.Atoi(value); err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("port '%s' is not a valid port number", value)
+			}
+			host.port = value
+		case "user":
+			host.user = value
+		case "identity-file":
+			host.identityFile = value
+		case "connect-timeout":
+			timeout, err := parseTimeout(value)
+			if err != nil {
+				return nil, fmt.Errorf("connect-timeout '%s' is not a number of seconds or a duration like 1m30s", value)
+			}
+			host.connectTimeout = timeout
+		case "via":
+			host.via = value
+		case "sudo":
+			if value != SUDO_NOPASSWD && value != SUDO_PASSWORD {
+				return nil, fmt.Errorf("sudo '%s' must be either %s or %s", value, SUDO_NOPASSWD, SUDO_PASSWORD)
+			}
+			host.sudo = value
+		default:
+			return nil, fmt.Errorf("unknown setting '%s'", item.Key())
+		}
+	}
+
+	if host.address == "" {
+		return nil, fmt.Errorf("no address specified")
+	}
+	return host, nil
+}
+
+// Parse a timeout that is either a plain number of seconds, like "10", or a duration, like "1m30s"
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Locate the lines of the given hostname's entry within the hostnames section of the figtree text
+// An entry is either a single "name ip" line, or a "name {" line through its closing brace
+// Returns the first and last line numbers of the entry, and false if there is no such entry
+func findHostnameEntry(lines []string, hostname string) (int, int, bool) {
+	depth := 0
+	inHostnames := false
+	first := -1
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		opens := strings.Count(line, "{")
+		closes := strings.Count(line, "}")
+
+		if depth == 0 && len(fields) > 0 && fields[0] == "hostnames" && opens > 0 {
+			inHostnames = true
+		} else if inHostnames && depth == 1 && first == -1 && len(fields) > 0 && fields[0] == hostname {
+			first = i
+		}
+
+		depth += opens - closes
+		if first != -1 && depth <= 1 {
+			return first, i, true
+		}
+		if depth <= 0 {
+			inHostnames = false
+			depth = 0
+		}
+	}
+	return 0, 0, false
+}
+
+// Returns true if the hostnames configuration has an entry for the given hostname
+func hasHostnameEntry(hostname string) bool {
+	contents, err := ioutil.ReadFile(HOSTNAMES_CONF)
+	if err != nil {
+		return false
+	}
+	_, _, found := findHostnameEntry(strings.Split(string(contents), "\n"), hostname)
+	return found
+}
+
+// Remove the given hostname's entry from /etc/neph/conf/hostnames, leaving the rest of the file untouched
+func removeHostnameEntry(hostname string) error {
+	info, err := os.Stat(HOSTNAMES_CONF)
+	if err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(HOSTNAMES_CONF)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(contents), "\n")
+	first, last, found := findHostnameEntry(lines, hostname)
+	if !found {
+		return nil
+	}
+	lines = append(lines[:first], lines[last+1:]...)
+
+	tempFile := HOSTNAMES_CONF + ".tmp"
+	if err := ioutil.WriteFile(tempFile, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return os.Rename(tempFile, HOSTNAMES_CONF)
+}
@@ -0,0 +1,64 @@
+//=============================================================================
+// File:     hostnames_test.go
+// Contents: Tests of locating a host's entry in the hostnames figtree
+//=============================================================================
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindHostnameEntry(t *testing.T) {
+	figtree := strings.Split(`nk023 {
+    ip 10.0.0.99
+}
+hostnames {
+    nk024 10.0.0.24
+    nk025 {
+        ip 10.0.0.25
+        user pi
+        via {
+            host nk024
+        }
+    }
+    nk026 {ip 10.0.0.26}
+    nk027 10.0.0.27
+}
+other {
+    nk028 10.0.0.28
+}`, "\n")
+
+	tests := []struct {
+		hostname string
+		first    int
+		last     int
+		found    bool
+	}{
+		{"nk024", 4, 4, true},
+		{"nk025", 5, 11, true},
+		{"nk026", 12, 12, true},
+		{"nk027", 13, 13, true},
+		{"nk023", 0, 0, false}, // outside of the hostnames section
+		{"nk028", 0, 0, false}, // in another section
+		{"ip", 0, 0, false},    // a setting within an entry
+		{"host", 0, 0, false},  // a setting nested deeper within an entry
+		{"nk02", 0, 0, false},
+	}
+	for _, test := range tests {
+		first, last, found := findHostnameEntry(figtree, test.hostname)
+		if first != test.first || last != test.last || found != test.found {
+			t.Errorf("findHostnameEntry(%s) = %d, %d, %v, want %d, %d, %v",
+				test.hostname, first, last, found, test.first, test.last, test.found)
+		}
+	}
+}
+
+func TestFindHostnameEntryEmpty(t *testing.T) {
+	for _, figtree := range []string{"", "hostnames {\n}", "hostnames {}"} {
+		if _, _, found := findHostnameEntry(strings.Split(figtree, "\n"), "nk024"); found {
+			t.Errorf("found nk024 in %q", figtree)
+		}
+	}
+}
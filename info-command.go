@@ -17,8 +17,8 @@ func commandInfoHosts(host string, options []string) Exitcode {
 	if host == "localhost" {
 		configuredHosts, exitCode := GetAllHostnames()
 		if exitCode == SUCCESS {
-			for hostname, ipAddress := range configuredHosts {
-				fmt.Printf("%s %s\n", hostname, ipAddress)
+			for hostname, host := range configuredHosts {
+				fmt.Printf("%s %s\n", hostname, host.address)
 			}
 		}
 		return exitCode
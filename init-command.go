@@ -5,14 +5,104 @@
 
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
 
-// Handle "neph init remoteHost"
+// Handle "neph init remoteHost [--privileged]"
 func commandInit(host string, options []string) Exitcode {
+	if isLocalhost(host) {
+		fmt.Printf("neph init requires a remote host\n")
+		return CLI_BAD_ARGUMENTS
+	}
+
+	remote, exitCode := remoteFileSystem(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
 
 	fmt.Printf("--- Begin neph init on %s ---\n", host)
 	defer fmt.Printf("--- End neph init on %s ---\n", host)
 
-	writeHello(host)
+	local := &localFS{}
+
+	exitCode = installExecutable(local, remote)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	exitCode = syncNephTrees(local, remote, true)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	if hasOption(options, "--privileged") {
+		exitCode = installIdentityFile(local, remote)
+		if exitCode != SUCCESS {
+			return exitCode
+		}
+	}
+
+	// confirm that the remote host can now run neph commands
+	return remoteNephCommand(host, "neph version")
+}
+
+// Copy the currently running neph executable to /usr/bin/neph on the remote host
+// The executable is staged under a temporary name, so that a running copy on the remote host is never overwritten in place
+func installExecutable(local *localFS, remote syncFS) Exitcode {
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("unable to determine the path to the running neph executable: %v\n", err)
+		return FS_FAILURE
+	}
+	info, err := os.Stat(executable)
+	if err != nil {
+		fmt.Printf("unable to stat %s: %v\n", executable, err)
+		return FS_FAILURE
+	}
+
+	// copyFile renames the copy into place, so a neph that is running on the remote host is never overwritten
+	err = copyFile(local, executable, remote, NEPH_EXECUTABLE, info.ModTime(), NEPH_EXECUTABLE_MODE)
+	if err != nil {
+		fmt.Printf("unable to copy %s to %s:%s: %v\n", executable, remote.Name(), NEPH_EXECUTABLE, err)
+		return FS_FAILURE
+	}
+
+	fmt.Printf("%-10s %s\n", "installed", NEPH_EXECUTABLE)
+	return SUCCESS
+}
+
+// Copy the private SSH key to the remote host, elevating it to be a privileged device
+// Whichever type of key is in use on this device is sent, to the same location on the remote host
+func installIdentityFile(local *localFS, remote syncFS) Exitcode {
+	identityFile, err := findIdentityFile()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return SSH_LOCAL_CONFIGURATION_FAILURE
+	}
+	if _, exitCode := loadIdentity(identityFile); exitCode != SUCCESS {
+		return exitCode
+	}
+	info, err := os.Stat(identityFile)
+	if err != nil {
+		fmt.Printf("unable to read private key %s: %v\n", identityFile, err)
+		return SSH_LOCAL_CONFIGURATION_FAILURE
+	}
+
+	sshDir := filepath.Dir(identityFile)
+	if err := remote.MkdirAll(sshDir); err != nil {
+		fmt.Printf("unable to create %s on %s: %v\n", sshDir, remote.Name(), err)
+		return FS_FAILURE
+	}
+
+	err = copyFile(local, identityFile, remote, identityFile, info.ModTime(), SSH_IDENTITY_MODE)
+	if err != nil {
+		fmt.Printf("unable to copy %s to %s: %v\n", identityFile, remote.Name(), err)
+		return FS_FAILURE
+	}
+
+	fmt.Printf("%-10s %s\n", "installed", identityFile)
 	return SUCCESS
 }
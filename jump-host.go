@@ -0,0 +1,100 @@
+//=============================================================================
+// File:     jump-host.go
+// Contents: Reach private hosts by tunnelling through one or more jump hosts
+//=============================================================================
+
+package main
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The jump host given with --via, which overrides the via setting of the host being connected to
+var viaOption string
+
+// The jump host to reach the host through, or an empty string to connect to it directly
+func effectiveVia(host *Host) string {
+	if viaOption != "" && viaOption != host.hostname {
+		return viaOption
+	}
+	return host.via
+}
+
+// Open a TCP connection to the host's SSH port
+// When via names a jump host, an SSH connection is made to it first (itself possibly through its own jump host),
+// and a direct-tcpip channel is opened from there to the host. The jump host's key is verified like any other.
+// The returned jump client, if not nil, must be closed once the connection is finished with.
+func openTransport(host *Host, via string, chain []string) (net.Conn, *ssh.Client, Exitcode) {
+	if via == "" {
+		dialer := &net.Dialer{Timeout: connectTimeout(host)}
+		conn, err := dialer.DialContext(interruptContext, "tcp", host.dialAddress())
+		if err != nil && isInterrupted() {
+			return nil, nil, RUN_INTERRUPTED
+		}
+		if err != nil {
+			hostPrintf(host.hostname, "failed to dial %s: %v\n", host.dialAddress(), err)
+			return nil, nil, SSH_CONNECTION_FAILURE
+		}
+		return conn, nil, SUCCESS
+	}
+
+	for _, hostname := range chain {
+		if hostname == via {
+			hostPrintf(host.hostname, "jump hosts form a loop: %s -> %s\n", strings.Join(chain, " -> "), via)
+			return nil, nil, NEPH_CONFIG_ERROR
+		}
+	}
+
+	jumpHost, exitCode := resolveHost(via)
+	if exitCode == HOST_NOT_FOUND {
+		hostPrintf(host.hostname, "unable to resolve jump host %s using %s, %s or DNS\n", via, HOSTNAMES_CONF, ETC_HOSTS)
+	}
+	if exitCode != SUCCESS {
+		return nil, nil, exitCode
+	}
+	if verbose {
+		hostPrintf(host.hostname, "reaching %s through %s\n", host.hostname, via)
+	}
+
+	jumpClient, exitCode := dialHost(jumpHost, jumpHost.via, append(chain, via))
+	if exitCode != SUCCESS {
+		return nil, nil, exitCode
+	}
+
+	// the jump host may take as long as it likes to give up on an unreachable host, so it is abandoned after the timeout
+	var timer *time.Timer
+	if timeout := connectTimeout(host); timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { jumpClient.Close() })
+	}
+	stopWatching := closeOnInterrupt(jumpClient)
+	conn, err := jumpClient.Dial("tcp", host.dialAddress())
+	timedOut := timer != nil && !timer.Stop()
+	stopWatching()
+	if err != nil && isInterrupted() {
+		jumpClient.Close()
+		return nil, nil, RUN_INTERRUPTED
+	}
+	if timedOut {
+		hostPrintf(host.hostname, "jump host %s failed to reach %s within %v\n", via, host.dialAddress(), connectTimeout(host))
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, nil, SSH_CONNECTION_FAILURE
+	}
+	if err != nil {
+		hostPrintf(host.hostname, "jump host %s failed to reach %s: %v\n", via, host.dialAddress(), err)
+		jumpClient.Close()
+		return nil, nil, SSH_CONNECTION_FAILURE
+	}
+	return conn, jumpClient, SUCCESS
+}
+
+// Close the jump host's connection once the connection tunnelled through it has been closed
+func closeWhenDone(clientConn *ssh.Client, jumpClient *ssh.Client) {
+	clientConn.Wait()
+	jumpClient.Close()
+}
@@ -0,0 +1,62 @@
+//=============================================================================
+// File:     keepalive.go
+// Contents: Detect SSH connections that have silently died
+//=============================================================================
+
+package main
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Send a keepalive request every SSH_KEEPALIVE_INTERVAL until the connection is closed
+// This keeps NAT gateways and firewalls from dropping a connection that is idle while a long script runs,
+// and closes the connection once SSH_KEEPALIVE_MAX_MISSED requests in a row go unanswered,
+// so that a session on a dead link fails instead of waiting forever
+func keepAlive(clientConn *ssh.Client, hostname string) {
+	closed := make(chan struct{})
+	go func() {
+		clientConn.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(SSH_KEEPALIVE_INTERVAL)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+
+		// any reply will do, even the failure that a server sends for a request it doesn't recognize
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := clientConn.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+
+		select {
+		case <-closed:
+			return
+		case err := <-replied:
+			if err == nil {
+				missed = 0
+				continue
+			}
+			missed++
+		case <-time.After(SSH_KEEPALIVE_INTERVAL):
+			missed++
+		}
+
+		if missed >= SSH_KEEPALIVE_MAX_MISSED {
+			hostPrintf(hostname, "%s stopped answering keepalives, closing the connection\n", hostname)
+			clientConn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,201 @@
+//=============================================================================
+// File:     known-hosts.go
+// Contents: Trust-on-first-use store of remote host keys in /etc/neph/known_hosts
+//=============================================================================
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The known_hosts file uses the OpenSSH format, with one "host keytype base64key" entry per line
+// The first time neph connects to a host, the host key it presents is recorded.
+// Every later connection must present the same key, or it is refused.
+
+// Get the host keys recorded for the given address, like "nk024:22"
+// Returns an empty list if the host has never been contacted
+func knownHostKeys(address string) ([]ssh.PublicKey, error) {
+	contents, err := ioutil.ReadFile(KNOWN_HOSTS_FILE)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for _, line := range strings.Split(string(contents), "\n") {
+		if hostKey := matchKnownHostLine(line, address); hostKey != nil {
+			keys = append(keys, hostKey)
+		}
+	}
+	return keys, nil
+}
+
+// Check a single line of the known_hosts file
+// Returns the key if the line is an entry for the given address, otherwise nil
+func matchKnownHostLine(line string, address string) ssh.PublicKey {
+	_, hosts, hostKey, _, _, err := ssh.ParseKnownHosts([]byte(line))
+	if err != nil {
+		return nil
+	}
+	normalized := knownhosts.Normalize(address)
+	for _, host := range hosts {
+		if host == normalized {
+			return hostKey
+		}
+	}
+	return nil
+}
+
+// Append an entry for the address to the known_hosts file
+func addKnownHostKey(address string, hostKey ssh.PublicKey) error {
+	file, err := os.OpenFile(KNOWN_HOSTS_FILE, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(knownhosts.Line([]string{address}, hostKey) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Remove every entry for the address from the known_hosts file, leaving all other lines untouched
+// Returns the number of entries removed
+func forgetKnownHostKeys(address string) (int, error) {
+	contents, err := ioutil.ReadFile(KNOWN_HOSTS_FILE)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var kept []string
+	removed := 0
+	for _, line := range strings.Split(string(contents), "\n") {
+		if matchKnownHostLine(line, address) != nil {
+			removed++
+		} else {
+			kept = append(kept, line)
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, replaceFileContents(KNOWN_HOSTS_FILE, []byte(strings.Join(kept, "\n")))
+}
+
+// Returns a short description of the key, like "ssh-ed25519 SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s"
+func describeHostKey(hostKey ssh.PublicKey) string {
+	return hostKey.Type() + " " + ssh.FingerprintSHA256(hostKey)
+}
+
+// The hostKeyVerifier type checks the key presented by a host against the known_hosts file while connecting
+type hostKeyVerifier struct {
+	host     string          // as given on the command line, like "nk024"
+	address  string          // like "nk024:22"
+	known    []ssh.PublicKey // the keys recorded for the address, if any
+	mismatch bool            // true if the host presented a key that differs from the recorded ones
+}
+
+// Create a verifier for the given host and its address, like "nk024:22"
+func newHostKeyVerifier(host string, address string) (*hostKeyVerifier, error) {
+	known, err := knownHostKeys(address)
+	if err != nil {
+		return nil, err
+	}
+	return &hostKeyVerifier{host: host, address: address, known: known}, nil
+}
+
+// The host key algorithms to negotiate
+// Once a key has been recorded, the host must present a key of that same type,
+// otherwise a host offering several key types could appear to have changed its key
+// Returns nil for a host that has never been contacted, allowing any supported algorithm
+func (v *hostKeyVerifier) algorithms() []string {
+	var algorithms []string
+	for _, hostKey := range v.known {
+		algorithms = append(algorithms, hostKeyAlgorithms(hostKey.Type())...)
+	}
+	return algorithms
+}
+
+// The signature algorithms that a host key of the given type can be verified with, strongest first
+// An RSA key signs with SHA-2 as well as the SHA-1 of its own type name, which OpenSSH 8.8 and later refuse by default
+func hostKeyAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	default:
+		return []string{keyType}
+	}
+}
+
+// The ssh.HostKeyCallback used when connecting
+// Records the key on first contact, accepts a key that matches the recorded one, and rejects any other
+func (v *hostKeyVerifier) callback(hostname string, remote net.Addr, hostKey ssh.PublicKey) error {
+	if len(v.known) == 0 {
+		if err := addKnownHostKey(v.address, hostKey); err != nil {
+			return fmt.Errorf("unable to record host key in %s: %w", KNOWN_HOSTS_FILE, err)
+		}
+		hostPrintf(v.host, "first contact with %s, recorded its host key %s\n", v.host, describeHostKey(hostKey))
+		return nil
+	}
+
+	for _, knownKey := range v.known {
+		if bytes.Equal(knownKey.Marshal(), hostKey.Marshal()) {
+			return nil
+		}
+	}
+
+	v.mismatch = true
+	hostPrintf(v.host, "WARNING: THE HOST KEY OF %s HAS CHANGED\n", v.host)
+	hostPrintf(v.host, "it presented %s\n", describeHostKey(hostKey))
+	for _, knownKey := range v.known {
+		hostPrintf(v.host, "but %s records %s\n", KNOWN_HOSTS_FILE, describeHostKey(knownKey))
+	}
+	hostPrintf(v.host, "someone could be intercepting the connection; if the change is expected, run 'neph hostkey accept %s'\n", v.host)
+	return fmt.Errorf("host key mismatch for %s", v.host)
+}
+
+// Connect just far enough to learn the host key that the host presents, without authenticating
+// A host behind a jump host is reached through it, verifying the jump host's own key as usual
+func fetchHostKey(host *Host) (ssh.PublicKey, Exitcode) {
+	var presented ssh.PublicKey
+	errKeyReceived := errors.New("host key received")
+
+	clientConfig := &ssh.ClientConfig{
+		User: host.user,
+		HostKeyCallback: func(hostname string, remote net.Addr, hostKey ssh.PublicKey) error {
+			presented = hostKey
+			return errKeyReceived
+		},
+	}
+
+	conn, jumpClient, exitCode := openTransport(host, effectiveVia(host), []string{host.hostname})
+	if exitCode != SUCCESS {
+		return nil, exitCode
+	}
+	if jumpClient != nil {
+		defer jumpClient.Close()
+	}
+	defer conn.Close()
+
+	_, _, _, err := ssh.NewClientConn(conn, host.dialAddress(), clientConfig)
+	if presented == nil {
+		hostPrintf(host.hostname, "unable to obtain the host key of %s: %v\n", host.hostname, err)
+		return nil, SSH_CONNECTION_FAILURE
+	}
+	return presented, SUCCESS
+}
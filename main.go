@@ -8,7 +8,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,6 +20,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the arguments after "--" belong to the exec script, even the ones that look like neph's options
+	nephOptions := beforeDoubleDash(os.Args[1:])
+	verbose = hasOption(nephOptions, "--verbose")
+	viaOption = optionValue(nephOptions, "--via", "")
+	userOption = optionValue(nephOptions, "--user", "")
+	noInstall = hasOption(nephOptions, "--no-install")
+	prefixOutput = hasOption(nephOptions, "--prefix")
+	if exitCode := parseConnectionOptions(nephOptions); exitCode != SUCCESS {
+		os.Exit(int(exitCode))
+	}
+	if exitCode := parseRunOptions(nephOptions); exitCode != SUCCESS {
+		os.Exit(int(exitCode))
+	}
+	watchSignals()
+
 	// determine which command line pattern to follow
 	var pattern string
 	var argdump string
@@ -34,6 +48,10 @@ func main() {
 		} else if isMetaCommand(argv) {
 			pattern += "metacommand "
 			argdump += "metacommand: " + argv + "\n"
+		} else if isHostList(argv) {
+			// checked before resolving, so that DNS can't turn "all" into a single host like all.example.com
+			pattern += "hostlist "
+			argdump += "hostlist: " + argv + "\n"
 		} else if isLocalhost(argv) {
 			pattern += "localhost "
 			argdump += "localhost: " + argv + "\n"
@@ -54,7 +72,11 @@ func main() {
 
 	var exitCode Exitcode
 
-	if strings.HasPrefix(pattern, "command subcommand host") {
+	if strings.HasPrefix(pattern, "command subcommand hostlist") {
+		fmt.Printf("neph %s %s takes a single host, only neph exec accepts a list like '%s'\n", os.Args[1], os.Args[2], os.Args[3])
+		exitCode = CLI_BAD_ARGUMENTS
+
+	} else if strings.HasPrefix(pattern, "command subcommand host") {
 		exitCode = executeSubCommand(os.Args[1], os.Args[2], os.Args[3], os.Args[4:])
 
 	} else if strings.HasPrefix(pattern, "command subcommand localhost") {
@@ -63,6 +85,9 @@ func main() {
 	} else if strings.HasPrefix(pattern, "command subcommand") {
 		exitCode = executeSubCommand(os.Args[1], os.Args[2], "localhost", os.Args[3:])
 
+	} else if strings.HasPrefix(pattern, "command hostlist") {
+		exitCode = executeHostListCommand(os.Args[1], os.Args[2], os.Args[3:])
+
 	} else if strings.HasPrefix(pattern, "command host") {
 		exitCode = executeCommand(os.Args[1], os.Args[2], os.Args[3:])
 
@@ -81,13 +106,18 @@ func main() {
 		fmt.Printf("Try neph help\n")
 		exitCode = CLI_BAD_ARGUMENTS
 	}
+	// an interrupted command has returned early, having cleaned up after itself
+	if isInterrupted() {
+		exitCode = RUN_INTERRUPTED
+	}
+	closeAllConnections()
 	ec := int(exitCode)
 	os.Exit(ec)
 }
 
 func isCommand(argv string) bool {
 	switch argv {
-	case "init", "push", "pull", "scrub", "info", "apply", "examine", "exec":
+	case "init", "push", "pull", "scrub", "info", "apply", "examine", "exec", "hostkey":
 		return true
 	default:
 		return false
@@ -96,7 +126,7 @@ func isCommand(argv string) bool {
 
 func isSubCommand(argv string) bool {
 	switch argv {
-	case "hosts", "configs", "scripts":
+	case "hosts", "configs", "scripts", "show", "forget", "accept":
 		return true
 	default:
 		return false
@@ -114,13 +144,66 @@ func isMetaCommand(argv string) bool {
 
 func isOption(argv string) bool {
 	switch argv {
-	case "--privileged", "--force":
+	case "--privileged", "--force", "--yes", "--raw", "--dry-run", "--verbose", "--no-install", "--prefix", "--ephemeral":
+		return true
+	default:
+		return isValueOption(argv)
+	}
+}
+
+// Returns true if the option is followed by a value, like "--block name"
+func isValueOption(argv string) bool {
+	switch argv {
+	case "--block", "--comment-style", "--via", "--connect-timeout", "--retries", "--user", "--env", "--env-file", "--parallel", "--timeout":
 		return true
 	default:
 		return false
 	}
 }
 
+// Returns true if the given option was provided on the command line
+func hasOption(options []string, option string) bool {
+	for _, opt := range options {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value that follows the given option on the command line, or defaultValue if the option wasn't provided
+func optionValue(options []string, option string, defaultValue string) string {
+	for i := 0; i < len(options)-1; i++ {
+		if options[i] == option {
+			return options[i+1]
+		}
+	}
+	return defaultValue
+}
+
+// Returns the arguments that come before "--", which are the only ones that may be neph's own options
+func beforeDoubleDash(args []string) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i]
+		}
+	}
+	return args
+}
+
+// Returns the arguments that are neither options nor the values that follow them
+func positionalArgs(options []string) []string {
+	var positional []string
+	for i := 0; i < len(options); i++ {
+		if isValueOption(options[i]) {
+			i++
+		} else if !isOption(options[i]) {
+			positional = append(positional, options[i])
+		}
+	}
+	return positional
+}
+
 func isScript(argv string) bool {
 	scriptPath := filepath.Join("/var/neph/scripts", argv)
 	if _, err := os.Stat(scriptPath); errors.Is(err, os.ErrNotExist) {
@@ -143,17 +226,16 @@ func isLocalhost(argv string) bool {
 	return false
 }
 
+// Returns true if the argument names a remote host, found in the hostnames figtree, /etc/hosts or DNS
 func isRemotehost(argv string) bool {
-	iprecords, err := net.LookupIP(argv)
-	if err != nil {
+	if strings.HasPrefix(argv, "-") {
 		return false
 	}
-	for _, ip := range iprecords {
-		if string(ip) == "127.0.0.1" {
-			return false
-		}
+	host, exitCode := resolveHost(argv)
+	if exitCode != SUCCESS {
+		return false
 	}
-	return true
+	return !isLoopbackAddress(host.address)
 }
 
 // execute a neph command
@@ -201,6 +283,15 @@ func executeSubCommand(command string, subcommand string, host string, options [
 
 	case "info scripts":
 		return commandInfoScripts(host, options)
+
+	case "hostkey show":
+		return commandHostkeyShow(host, options)
+
+	case "hostkey forget":
+		return commandHostkeyForget(host, options)
+
+	case "hostkey accept":
+		return commandHostkeyAccept(host, options)
 	}
 
 	fmt.Printf("Unhandled command '%s %s'\n", command, subcommand)
@@ -0,0 +1,92 @@
+//=============================================================================
+// File:     output-stream.go
+// Contents: Pass the output of scripts and remote commands through as it arrives
+//=============================================================================
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// True when --prefix was given on the command line, so that each line of output is labeled with its host
+var prefixOutput bool
+
+// Serializes the lines written by every lineWriter, so that the output of hosts running at the same time
+// is interleaved a whole line at a time
+var outputMutex sync.Mutex
+
+// The lineWriter type passes output through a line at a time, as soon as each line is complete,
+// labeling each one with a prefix
+type lineWriter struct {
+	out     io.Writer // os.Stdout or os.Stderr
+	prefix  string    // like "[nk024] ", or empty
+	partial []byte    // the start of a line whose newline hasn't arrived yet
+}
+
+// Create the writers for a command's stdout and stderr
+// When label isn't empty, stdout lines are prefixed with "[label] " and stderr lines with "[label stderr] ",
+// otherwise the lines are passed through unchanged, with stderr kept separate by going to neph's own stderr
+func newOutputStreams(label string) (*lineWriter, *lineWriter) {
+	stdout := &lineWriter{out: os.Stdout}
+	stderr := &lineWriter{out: os.Stderr}
+	if label != "" {
+		stdout.prefix = "[" + label + "] "
+		stderr.prefix = "[" + label + " stderr] "
+	}
+	return stdout, stderr
+}
+
+// The label to prefix a host's output with, which is empty unless --prefix was given
+func outputLabel(host string) string {
+	if prefixOutput {
+		return host
+	}
+	return ""
+}
+
+// Print a message about the host, labeled like the host's output when --prefix is in effect,
+// so that the messages of hosts worked on at the same time can be told apart
+func hostPrintf(host string, format string, args ...interface{}) {
+	stdout, _ := newOutputStreams(outputLabel(host))
+	fmt.Fprintf(stdout, format, args...)
+	stdout.Flush()
+}
+
+// Write every complete line, keeping any incomplete one until the rest of it arrives
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i == -1 {
+			break
+		}
+		if err := w.writeLine(w.partial[:i+1]); err != nil {
+			return len(p), err
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Write the last line, if the output didn't end with a newline
+func (w *lineWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	line := append(w.partial, '\n')
+	w.partial = nil
+	return w.writeLine(line)
+}
+
+// The prefix and the line are written together, so that nothing printed elsewhere can land between them
+func (w *lineWriter) writeLine(line []byte) error {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	_, err := w.out.Write(append([]byte(w.prefix), line...))
+	return err
+}
@@ -0,0 +1,123 @@
+//=============================================================================
+// File:     package-manager.go
+// Contents: Install missing tools with the package manager of the local Linux distribution
+//=============================================================================
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// True when --no-install was given on the command line, so that missing tools are reported rather than installed
+var noInstall bool
+
+// The packageManager type describes how to install packages on a family of Linux distributions
+type packageManager struct {
+	name    string   // the executable, like "apt-get"
+	refresh []string // the arguments that update the package index, when an install fails because it is out of date
+	install []string // the arguments that precede the package name, and install without asking questions
+	env     []string // additional environment variables that keep the install non-interactive
+	distros []string // the os-release IDs of the distributions that use it
+}
+
+// Every supported package manager, in order of preference for distributions that have more than one
+var packageManagers = []*packageManager{
+	{"dnf", nil, []string{"install", "-y"}, nil, []string{"fedora", "rhel", "centos", "rocky", "almalinux", "ol", "amzn"}},
+	{"yum", nil, []string{"install", "-y"}, nil, []string{"fedora", "rhel", "centos", "rocky", "almalinux", "ol", "amzn"}},
+	{"apt-get", []string{"update"}, []string{"install", "-y"}, []string{"DEBIAN_FRONTEND=noninteractive"}, []string{"debian", "ubuntu", "raspbian", "linuxmint"}},
+	{"apk", nil, []string{"add", "--no-cache"}, nil, []string{"alpine"}},
+	{"zypper", nil, []string{"--non-interactive", "install"}, nil, []string{"opensuse", "opensuse-leap", "opensuse-tumbleweed", "sles", "suse"}},
+}
+
+// Choose the package manager of this device
+// The distribution is identified by the ID and ID_LIKE fields of /etc/os-release, and the first of its
+// package managers that is installed is chosen. (Older Red Hat releases have yum but not dnf.)
+// Returns the package manager and the os-release ID it was chosen for,
+// or an error if the distribution is unknown, or none of its package managers are installed
+func detectPackageManager() (*packageManager, string, error) {
+	distros, err := osReleaseIDs()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, distro := range distros {
+		for _, manager := range packageManagers {
+			if !manager.supports(distro) {
+				continue
+			}
+			if _, err := exec.LookPath(manager.name); err == nil {
+				return manager, distro, nil
+			}
+		}
+	}
+	return nil, "", fmt.Errorf("no supported package manager found for %s (dnf, yum, apt-get, apk, zypper)", strings.Join(distros, ", "))
+}
+
+// Returns true if the package manager is used by the distribution with the given os-release ID
+func (m *packageManager) supports(distro string) bool {
+	for _, d := range m.distros {
+		if d == distro {
+			return true
+		}
+	}
+	return false
+}
+
+// Install the named package, printing the package manager's output
+// The package index is only updated, and the install tried again, when the first attempt fails,
+// since an out of date index is the usual reason a package can't be found
+func (m *packageManager) installPackage(distributionPackage string) error {
+	install := append(append([]string{}, m.install...), distributionPackage)
+	err := m.run(install)
+	if err == nil || m.refresh == nil {
+		return err
+	}
+	if err := m.run(m.refresh); err != nil {
+		return err
+	}
+	return m.run(install)
+}
+
+// Run the package manager with the given arguments
+func (m *packageManager) run(args []string) error {
+	cmd := exec.Command(m.name, args...)
+	cmd.Env = append(os.Environ(), m.env...)
+	out, err := cmd.CombinedOutput()
+	fmt.Print(string(out))
+	return err
+}
+
+// Read the ID and ID_LIKE fields of /etc/os-release
+// Returns the distribution's own ID first, followed by the IDs of the distributions it derives from
+func osReleaseIDs() ([]string, error) {
+	file, err := os.Open(OS_RELEASE_FILE)
+	if err != nil {
+		return nil, fmt.Errorf("unable to identify the Linux distribution: %w", err)
+	}
+	defer file.Close()
+
+	var id string
+	var idLike []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "ID=") {
+			id = unquoteOSReleaseValue(strings.TrimPrefix(line, "ID="))
+		} else if strings.HasPrefix(line, "ID_LIKE=") {
+			idLike = strings.Fields(unquoteOSReleaseValue(strings.TrimPrefix(line, "ID_LIKE=")))
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("unable to identify the Linux distribution: %s has no ID", OS_RELEASE_FILE)
+	}
+	return append([]string{id}, idLike...), nil
+}
+
+// Remove the quotes that may surround an os-release value, like "rhel fedora"
+func unquoteOSReleaseValue(value string) string {
+	return strings.Trim(value, `"'`)
+}
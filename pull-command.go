@@ -5,7 +5,24 @@
 
 package main
 
-// Handle "neph pull remoteHost"
+import "fmt"
+
+// Handle "neph pull remoteHost [--force]"
+// Copy missing files, update older files, and delete obsolete files on the localhost
 func commandPull(host string, options []string) Exitcode {
-	return SUCCESS
+	if isLocalhost(host) {
+		fmt.Printf("neph pull requires a remote host\n")
+		return CLI_BAD_ARGUMENTS
+	}
+
+	remote, exitCode := remoteFileSystem(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	fmt.Printf("--- Begin neph pull from %s ---\n", host)
+	defer fmt.Printf("--- End neph pull from %s ---\n", host)
+
+	force := hasOption(options, "--force")
+	return syncNephTrees(remote, &localFS{}, force)
 }
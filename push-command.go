@@ -5,7 +5,24 @@
 
 package main
 
-// Handle "neph push remoteHost"
+import "fmt"
+
+// Handle "neph push remoteHost [--force]"
+// Copy missing files, update older files, and delete obsolete files on the remote host
 func commandPush(host string, options []string) Exitcode {
-	return SUCCESS
+	if isLocalhost(host) {
+		fmt.Printf("neph push requires a remote host\n")
+		return CLI_BAD_ARGUMENTS
+	}
+
+	remote, exitCode := remoteFileSystem(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+
+	fmt.Printf("--- Begin neph push to %s ---\n", host)
+	defer fmt.Printf("--- End neph push to %s ---\n", host)
+
+	force := hasOption(options, "--force")
+	return syncNephTrees(&localFS{}, remote, force)
 }
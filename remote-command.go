@@ -6,8 +6,7 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -17,37 +16,77 @@ import (
 // Returns the final exitCode of the remote neph CLI command
 func remoteNephCommand(remoteHost string, nephCommand string) Exitcode {
 
-	clientConn, exitCode := connectViaSSH(remoteHost)
+	clientConn, exitCode := hostConnection(remoteHost)
 	if exitCode != SUCCESS {
 		return exitCode
 	}
-	defer clientConn.Close()
+
+	// neph needs root privileges, which an unprivileged login user gains through sudo
+	host, exitCode := resolveTargetHost(remoteHost)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	command, stdin, err := privilegedCommand(host, nephCommand)
+	if err != nil {
+		hostPrintf(remoteHost, "%v\n", err)
+		return SSH_LOCAL_CONFIGURATION_FAILURE
+	}
 
 	session, err := clientConn.NewSession()
 	if err != nil {
-		fmt.Printf("failed to create session: %v\n", err)
+		hostPrintf(remoteHost, "failed to create session: %v\n", err)
 		return SSH_SESSION_FAILURE
 	}
 	defer session.Close()
 
-	fmt.Printf("--- Begin remote neph command on %s ---\n", remoteHost)
-	defer fmt.Printf("--- End remote neph command on %s ---\n", remoteHost)
+	hostPrintf(remoteHost, "--- Begin remote neph command on %s ---\n", remoteHost)
+	defer hostPrintf(remoteHost, "--- End remote neph command on %s ---\n", remoteHost)
 
-	var b bytes.Buffer
-	session.Stdout = &b
+	stdout, stderr := newOutputStreams(outputLabel(remoteHost))
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
 
-	err = session.Run(nephCommand)
+	err = runSession(session, command, remoteHost)
+	stdout.Flush()
+	stderr.Flush()
 	if err != nil {
 		if err.Error() == "Process exited with status 127" {
-			fmt.Printf("'%s' can't be executed until you setup the remote host with 'neph init %s'\n", nephCommand, remoteHost)
+			hostPrintf(remoteHost, "'%s' can't be executed until you setup the remote host with 'neph init %s'\n", nephCommand, remoteHost)
 		} else {
-			fmt.Printf("failed to run '%s' on '%s': %v\n", nephCommand, remoteHost, err)
+			hostPrintf(remoteHost, "failed to run '%s' on '%s': %v\n", nephCommand, remoteHost, err)
 		}
-		ee := err.(*ssh.ExitError)
-		rc := Exitcode(ee.Waitmsg.ExitStatus())
-		return rc
+		return remoteExitCode(err)
 	}
-
-	fmt.Printf("%s", b.String())
 	return SUCCESS
 }
+
+// The exit code to report for a remote command that failed
+// That is the command's own exit status, unless it was stopped by --timeout or an interrupt,
+// or the connection was lost before the command reported one
+func remoteExitCode(err error) Exitcode {
+	if exitCode := stoppedExitCode(err); exitCode != SUCCESS {
+		return exitCode
+	}
+	if exitError, ok := err.(*ssh.ExitError); ok {
+		return Exitcode(exitError.Waitmsg.ExitStatus())
+	}
+	return SSH_SESSION_FAILURE
+}
+
+// Quote an argument so that the remote shell passes it verbatim to the command
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// Build the options that select a delimited block, for passing along to a remote neph command
+func remoteBlockOptions(blockName string, style *commentStyle) string {
+	var remoteOptions string
+	if blockName != DEFAULT_BLOCK_NAME {
+		remoteOptions += " --block " + shellQuote(blockName)
+	}
+	if style != nil {
+		remoteOptions += " --comment-style " + shellQuote(style.name)
+	}
+	return remoteOptions
+}
@@ -0,0 +1,47 @@
+//=============================================================================
+// File:     remote-temp.go
+// Contents: Private temporary directories for files staged briefly on a remote host
+//=============================================================================
+
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Create a private temporary directory on the remote host, owned by root
+// mktemp creates it with mode 0700 and a name no one else can predict,
+// so no one else can create or replace the files in it between their upload and their use
+func createRemoteTempDir(host string) (string, Exitcode) {
+	clientConn, exitCode := hostConnection(host)
+	if exitCode != SUCCESS {
+		return "", exitCode
+	}
+	settings, exitCode := resolveTargetHost(host)
+	if exitCode != SUCCESS {
+		return "", exitCode
+	}
+
+	var out bytes.Buffer
+	if err := runPrivileged(clientConn, settings, "mktemp -d "+REMOTE_TEMP_TEMPLATE, &out); err != nil {
+		hostPrintf(host, "unable to create a temporary directory on %s: %v\n", host, err)
+		return "", SSH_SESSION_FAILURE
+	}
+	return strings.TrimSpace(out.String()), SUCCESS
+}
+
+// Remove the temporary directory and everything in it
+func removeRemoteTempDir(host string, tempDir string) {
+	clientConn, exitCode := hostConnection(host)
+	if exitCode != SUCCESS {
+		return
+	}
+	settings, exitCode := resolveTargetHost(host)
+	if exitCode != SUCCESS {
+		return
+	}
+	if err := runPrivileged(clientConn, settings, "rm -rf -- "+shellQuote(tempDir), nil); err != nil {
+		hostPrintf(host, "unable to remove %s from %s: %v\n", tempDir, host, err)
+	}
+}
@@ -0,0 +1,195 @@
+//=============================================================================
+// File:     replace-file.go
+// Contents: Crash-safe replacement of a config file's contents
+//=============================================================================
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Replace the contents of the target file, keeping a copy of the original in target.bak
+// The new contents are written to a temporary file in the same directory, which is given the original's
+// mode, owner, group and extended attributes (including its SELinux label), flushed to disk,
+// and then renamed over the original. On any failure the original file is left untouched.
+// Once the rename has happened, failing to flush the directory to disk is only reported as a warning.
+func replaceFileContents(targetFile string, contents []byte) error {
+
+	// a symlinked config file is replaced at its destination, leaving the symlink intact
+	targetPath, err := filepath.EvalSymlinks(targetFile)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(targetPath)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(targetPath)
+	tempFile, err := ioutil.TempFile(dir, filepath.Base(targetPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	bCommitted := false
+	defer func() {
+		if !bCommitted {
+			tempFile.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	if _, err := tempFile.Write(contents); err != nil {
+		return err
+	}
+	if err := copyFileAttributes(targetPath, tempFile, info); err != nil {
+		return fmt.Errorf("unable to copy attributes of %s: %w", targetPath, err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	if err := backupFile(targetPath, info); err != nil {
+		return fmt.Errorf("unable to save %s to %s.bak: %w", targetPath, targetPath, err)
+	}
+	if err := os.Rename(tempPath, targetPath); err != nil {
+		return err
+	}
+	bCommitted = true
+
+	// make the rename itself durable; the new contents are in place by now, so a failure is only a warning
+	if err := syncDir(dir); err != nil {
+		fmt.Printf("warning: %s was replaced, but the change may not survive a crash: %v\n", targetPath, err)
+	}
+	return nil
+}
+
+// Give the open file the same permissions, ownership and extended attributes as the original
+func copyFileAttributes(originalPath string, file *os.File, info os.FileInfo) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := file.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+
+	// chmod after chown, because chown clears the setuid and setgid bits
+	if err := file.Chmod(info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+		return err
+	}
+
+	names, err := listXattrs(originalPath)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		value, err := getXattr(originalPath, name)
+		if err != nil {
+			return err
+		}
+		if err := unix.Fsetxattr(int(file.Fd()), name, value, 0); err != nil {
+			return fmt.Errorf("extended attribute %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the names of the file's extended attributes, or none if the file system doesn't support them
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Listxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// the names are NUL terminated strings, one after the other
+	var names []string
+	start := 0
+	for i := 0; i < size; i++ {
+		if buf[i] == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names, nil
+}
+
+// Returns the value of the named extended attribute
+func getXattr(path string, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, size)
+	size, err = unix.Getxattr(path, name, value)
+	if err != nil {
+		return nil, err
+	}
+	return value[:size], nil
+}
+
+// Preserve the original file as path.bak, replacing any previous backup
+// A hard link is used when possible, so the backup is the original itself, attributes and all;
+// otherwise the backup is a copy given the original's mode, owner, group and extended attributes
+func backupFile(path string, info os.FileInfo) error {
+	saveFile := path + ".bak"
+	if err := os.Remove(saveFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(path, saveFile); err == nil {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// created readable by its owner only, until it has the original's attributes
+	file, err := os.OpenFile(saveFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		os.Remove(saveFile)
+		return err
+	}
+	if err := copyFileAttributes(path, file, info); err != nil {
+		file.Close()
+		os.Remove(saveFile)
+		return err
+	}
+	return file.Close()
+}
+
+// Flush the directory entry changes, such as a rename, to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
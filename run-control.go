@@ -0,0 +1,245 @@
+//=============================================================================
+// File:     run-control.go
+// Contents: Stop scripts and remote neph commands that run too long, or that neph is interrupted during
+//=============================================================================
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// How long a script or remote neph command may run, from --timeout, or 0 to let it run indefinitely
+var runTimeout time.Duration
+
+// Cancelled when neph receives SIGINT or SIGTERM, after interruptSignal is set to the signal received
+// Connections being made and scripts and remote neph commands being run are abandoned, so that main can
+// clean up and exit
+var interruptContext, cancelOnInterrupt = context.WithCancel(context.Background())
+var interrupted = interruptContext.Done()
+var interruptSignal os.Signal
+
+// The errors reported for a run that was stopped, in place of its exit status
+var errRunTimedOut = errors.New("timed out")
+var errRunInterrupted = errors.New("interrupted")
+
+// Get the --timeout of scripts and remote neph commands
+func parseRunOptions(args []string) Exitcode {
+	if value := optionValue(args, "--timeout", ""); value != "" {
+		timeout, err := parseTimeout(value)
+		if err != nil || timeout < 0 {
+			fmt.Printf("--timeout '%s' is not a number of seconds or a duration like 1m30s\n", value)
+			return CLI_BAD_ARGUMENTS
+		}
+		runTimeout = timeout
+	}
+	return SUCCESS
+}
+
+// Catch SIGINT and SIGTERM, so that running scripts and remote neph commands are stopped rather than abandoned
+// The first signal cancels what is in progress, leaving main to remove temporary files and close the connections;
+// a second signal exits straight away, without waiting for that.
+func watchSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		interruptSignal = <-signals
+		fmt.Printf("\ninterrupted by %v, stopping (signal again to exit now)\n", interruptSignal)
+		cancelOnInterrupt()
+		<-signals
+		os.Exit(int(RUN_INTERRUPTED))
+	}()
+}
+
+// Close the connection if neph is interrupted before the returned function is called,
+// to abandon an attempt to connect that could otherwise take as long as the connect timeout
+func closeOnInterrupt(conn io.Closer) func() {
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupted:
+			conn.Close()
+		case <-finished:
+		}
+	}()
+	return func() { close(finished) }
+}
+
+// Read a password from the terminal without echoing it, giving up if neph is interrupted first
+// The terminal is put back the way it was, since the read it was switched to no echo for is abandoned
+// Returns errRunInterrupted if neph was interrupted
+func readPassword(fd int) ([]byte, error) {
+	state, err := terminal.GetState(fd)
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		password []byte
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		password, err := terminal.ReadPassword(fd)
+		done <- result{password, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.password, r.err
+	case <-interrupted:
+		terminal.Restore(fd, state)
+		return nil, errRunInterrupted
+	}
+}
+
+// Read a line from stdin, giving up if neph is interrupted first
+// Returns errRunInterrupted if neph was interrupted
+func readLine() (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		done <- result{line, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.line, r.err
+	case <-interrupted:
+		return "", errRunInterrupted
+	}
+}
+
+// Returns true once neph has received SIGINT or SIGTERM
+func isInterrupted() bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run the command in the session on the host, stopping it if it runs longer than --timeout or neph is interrupted
+// The remote process is sent the signal through the session; if the server doesn't deliver it, or the process
+// doesn't exit within the grace period, the session is closed
+// Returns the session's error, or errRunTimedOut or errRunInterrupted if it was stopped
+func runSession(session *ssh.Session, command string, host string) error {
+	if isInterrupted() {
+		return errRunInterrupted
+	}
+
+	if err := session.Start(command); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	stopSignal, stopErr := awaitRun(done, host)
+	if stopErr == nil {
+		return <-done
+	}
+
+	if err := session.Signal(stopSignal); err != nil {
+		session.Close()
+	}
+	select {
+	case <-done:
+	case <-time.After(RUN_STOP_GRACE_PERIOD):
+		session.Close()
+		<-done
+	}
+	return stopErr
+}
+
+// Run the command on the localhost, stopping it if it runs longer than --timeout or neph is interrupted
+// The process is sent the signal, and killed if it doesn't exit within the grace period
+// Returns the command's error, or errRunTimedOut or errRunInterrupted if it was stopped
+func runLocalCommand(cmd *exec.Cmd, host string) error {
+	if isInterrupted() {
+		return errRunInterrupted
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	stopSignal, stopErr := awaitRun(done, host)
+	if stopErr == nil {
+		return <-done
+	}
+
+	localSignal := os.Signal(syscall.SIGTERM)
+	if stopSignal == ssh.SIGINT {
+		localSignal = syscall.SIGINT
+	}
+	if err := cmd.Process.Signal(localSignal); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(RUN_STOP_GRACE_PERIOD):
+		cmd.Process.Kill()
+		<-done
+	}
+	return stopErr
+}
+
+// Wait for the run on the host to finish, or to time out, or for neph to be interrupted
+// Returns the signal to stop it with and why it must be stopped, or a nil error if it finished,
+// in which case its outcome is put back on done for the caller to receive
+func awaitRun(done chan error, host string) (ssh.Signal, error) {
+	var timeout <-chan time.Time
+	if runTimeout > 0 {
+		timer := time.NewTimer(runTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-done:
+		done <- err
+		return "", nil
+	case <-timeout:
+		hostPrintf(host, "timed out after %v, stopping it\n", runTimeout)
+		return ssh.SIGTERM, errRunTimedOut
+	case <-interrupted:
+		if interruptSignal == syscall.SIGINT {
+			return ssh.SIGINT, errRunInterrupted
+		}
+		return ssh.SIGTERM, errRunInterrupted
+	}
+}
+
+// The exit code to report for a run that was stopped, or SUCCESS if the error doesn't say it was
+func stoppedExitCode(err error) Exitcode {
+	switch {
+	case errors.Is(err, errRunTimedOut):
+		return RUN_TIMED_OUT
+	case errors.Is(err, errRunInterrupted):
+		return RUN_INTERRUPTED
+	default:
+		return SUCCESS
+	}
+}
@@ -0,0 +1,139 @@
+//=============================================================================
+// File:     script-args.go
+// Contents: Arguments and environment variables passed along to neph exec scripts
+//=============================================================================
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The name of an environment variable, as accepted by the shell
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// The execArgs type holds what "neph exec host script [args...] [-- args...]" asks for
+type execArgs struct {
+	script    string   // the script file name, relative to /var/neph/scripts, or any local path with --ephemeral
+	args      []string // the arguments passed to the script
+	env       []string // the environment variables set for the script, like "KEY=VALUE"
+	ephemeral bool     // true if --ephemeral came before "--", to upload the local script rather than run an installed one
+}
+
+// Separate the script, its arguments, and the --env and --env-file variables from the rest of the options
+// Before "--", neph's own options are set aside and everything else is passed to the script;
+// after "--", everything is passed to the script, even arguments that look like neph options
+func parseExecArgs(options []string) (*execArgs, Exitcode) {
+	parsed := &execArgs{}
+	var scriptArgs []string
+	for i := 0; i < len(options); i++ {
+		switch {
+		case options[i] == "--":
+			scriptArgs = append(scriptArgs, options[i+1:]...)
+			i = len(options)
+		case options[i] == "--env" && i+1 < len(options):
+			i++
+			if exitCode := parsed.addEnv(options[i], "--env"); exitCode != SUCCESS {
+				return nil, exitCode
+			}
+		case options[i] == "--env-file" && i+1 < len(options):
+			i++
+			if exitCode := parsed.addEnvFile(options[i]); exitCode != SUCCESS {
+				return nil, exitCode
+			}
+		case options[i] == "--ephemeral":
+			parsed.ephemeral = true
+		case isValueOption(options[i]):
+			i++
+		case isOption(options[i]):
+		default:
+			scriptArgs = append(scriptArgs, options[i])
+		}
+	}
+
+	if len(scriptArgs) == 0 {
+		fmt.Printf("neph exec requires the name of a script in %s\n", NEPH_SCRIPTS_DIR)
+		return nil, CLI_BAD_ARGUMENTS
+	}
+	parsed.script = scriptArgs[0]
+	parsed.args = scriptArgs[1:]
+	return parsed, SUCCESS
+}
+
+// Add a variable given as "KEY=VALUE"
+func (e *execArgs) addEnv(variable string, source string) Exitcode {
+	name := strings.SplitN(variable, "=", 2)[0]
+	if !strings.Contains(variable, "=") || !envNamePattern.MatchString(name) {
+		fmt.Printf("%s '%s' is not a variable assignment like KEY=VALUE\n", source, variable)
+		return CLI_BAD_ARGUMENTS
+	}
+	e.env = append(e.env, variable)
+	return SUCCESS
+}
+
+// Add the variables in a file of "KEY=VALUE" lines
+// Blank lines and comments are skipped, and an "export " before the name is allowed, so that the file may be sourced
+func (e *execArgs) addEnvFile(envFile string) Exitcode {
+	file, err := os.Open(envFile)
+	if err != nil {
+		fmt.Printf("unable to read --env-file %s: %v\n", envFile, err)
+		return FS_FAILURE
+	}
+	defer file.Close()
+
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		source := fmt.Sprintf("%s line %d:", envFile, lineNumber)
+		if exitCode := e.addEnv(line, source); exitCode != SUCCESS {
+			return exitCode
+		}
+	}
+	return SUCCESS
+}
+
+// The command line that runs the script with its arguments, each quoted for the remote shell
+func remoteScriptCommand(scriptPath string, args []string) string {
+	command := shellQuote(scriptPath)
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+	return command
+}
+
+// A prefix for a shell command that exports the variables to it, like "export KEY='VALUE'; "
+func envExportPrefix(env []string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	var assignments []string
+	for _, variable := range env {
+		nameValue := strings.SplitN(variable, "=", 2)
+		assignments = append(assignments, nameValue[0]+"="+shellQuote(nameValue[1]))
+	}
+	return "export " + strings.Join(assignments, " ") + "; "
+}
+
+// Ask the server to set the variables for the session
+// Returns false if the server refused any of them, which is the default unless its AcceptEnv setting lists them
+func setSessionEnv(session *ssh.Session, env []string) bool {
+	for _, variable := range env {
+		nameValue := strings.SplitN(variable, "=", 2)
+		if err := session.Setenv(nameValue[0], nameValue[1]); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+//=============================================================================
+// File:     script-args_test.go
+// Contents: Tests of the arguments passed along to neph exec scripts
+//=============================================================================
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExecArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   []string
+		script    string
+		args      []string
+		env       []string
+		ephemeral bool
+	}{
+		{"script only", []string{"hello"}, "hello", []string{}, nil, false},
+		{"script arguments", []string{"hello", "a", "b"}, "hello", []string{"a", "b"}, nil, false},
+		{"neph options set aside", []string{"--verbose", "hello", "--timeout", "5", "a"}, "hello", []string{"a"}, nil, false},
+		{"env", []string{"--env", "A=1", "hello", "--env", "B=x=y"}, "hello", []string{}, []string{"A=1", "B=x=y"}, false},
+		{"ephemeral", []string{"hello", "--ephemeral"}, "hello", []string{}, nil, true},
+		{"options after double dash", []string{"hello", "--", "--ephemeral", "--user", "bob", "--timeout", "5"}, "hello",
+			[]string{"--ephemeral", "--user", "bob", "--timeout", "5"}, nil, false},
+		{"script after double dash", []string{"--", "hello", "--env", "A=1"}, "hello", []string{"--env", "A=1"}, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, exitCode := parseExecArgs(test.options)
+			if exitCode != SUCCESS {
+				t.Fatalf("exit code %d", exitCode)
+			}
+			if parsed.script != test.script {
+				t.Errorf("script %q, want %q", parsed.script, test.script)
+			}
+			if !reflect.DeepEqual(parsed.args, test.args) {
+				t.Errorf("args %q, want %q", parsed.args, test.args)
+			}
+			if !reflect.DeepEqual(parsed.env, test.env) {
+				t.Errorf("env %q, want %q", parsed.env, test.env)
+			}
+			if parsed.ephemeral != test.ephemeral {
+				t.Errorf("ephemeral %v, want %v", parsed.ephemeral, test.ephemeral)
+			}
+		})
+	}
+}
+
+func TestParseExecArgsRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+	}{
+		{"no script", []string{"--verbose"}},
+		{"nothing after double dash", []string{"--"}},
+		{"env without a value", []string{"--env", "A", "hello"}},
+		{"env with a bad name", []string{"--env", "1A=x", "hello"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, exitCode := parseExecArgs(test.options); exitCode != CLI_BAD_ARGUMENTS {
+				t.Errorf("exit code %d, want %d", exitCode, CLI_BAD_ARGUMENTS)
+			}
+		})
+	}
+}
+
+func TestBeforeDoubleDash(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"exec", "h", "s", "--user", "bob"}, []string{"exec", "h", "s", "--user", "bob"}},
+		{[]string{"exec", "h", "s", "--", "--user", "bob"}, []string{"exec", "h", "s"}},
+		{[]string{"--"}, []string{}},
+	}
+	for _, test := range tests {
+		if got := beforeDoubleDash(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("beforeDoubleDash(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
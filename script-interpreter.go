@@ -0,0 +1,89 @@
+//=============================================================================
+// File:     script-interpreter.go
+// Contents: Install the interpreter named by a local script's #! line when it is missing
+//=============================================================================
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The packages that install each interpreter a neph script may name in its #! line,
+// by os-release ID or package manager, for distributions that don't name the package after the executable
+var interpreterPackages = map[string]map[string]string{
+	"bash":    {},
+	"sh":      {"apt-get": "dash", "dnf": "bash", "yum": "bash", "apk": "busybox", "zypper": "bash"},
+	"zsh":     {},
+	"python3": {},
+	"python":  {"apt-get": "python-is-python3", "dnf": "python-unversioned-command", "yum": "python3", "apk": "python3", "zypper": "python3"},
+	"perl":    {},
+	"ruby":    {},
+	"node":    {"apt-get": "nodejs", "dnf": "nodejs", "yum": "nodejs", "apk": "nodejs", "zypper": "nodejs"},
+	"php":     {"apt-get": "php-cli", "dnf": "php-cli", "yum": "php-cli", "apk": "php", "zypper": "php"},
+}
+
+// Make sure the interpreter named by the script's #! line is installed on the localhost, installing it if it isn't
+// Scripts without a #! line, and interpreters neph doesn't know the packages of, are left for the kernel to report
+func ensureScriptInterpreter(scriptPath string) Exitcode {
+	interpreter, err := readShebang(scriptPath)
+	if err != nil {
+		hostPrintf("localhost", "unable to read local script %s: %v\n", scriptPath, err)
+		return FS_FAILURE
+	}
+	if interpreter == "" {
+		return SUCCESS
+	}
+
+	cliTool := filepath.Base(interpreter)
+	distributionPackages, known := interpreterPackages[cliTool]
+	if !known {
+		return SUCCESS
+	}
+	// an absolute path names the interpreter exactly, so it must be that file that exists, not one elsewhere on the PATH
+	if filepath.IsAbs(interpreter) {
+		if _, err := os.Stat(interpreter); err == nil {
+			return SUCCESS
+		}
+	}
+	if _, err := findOrInstall(cliTool, distributionPackages); err != nil {
+		return INTERPRETER_INSTALL_FAILURE
+	}
+	return SUCCESS
+}
+
+// Get the interpreter named by the script's #! line, like "/bin/bash", or the command that /usr/bin/env is
+// asked to find, like "python3" for "#!/usr/bin/env python3"
+// Returns an empty string if the script has no #! line
+func readShebang(scriptPath string) (string, error) {
+	file, err := os.Open(scriptPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && line == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return "", nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	if filepath.Base(fields[0]) != "env" {
+		return fields[0], nil
+	}
+	// skip the options of env, like -S, to reach the command it runs
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+			return field, nil
+		}
+	}
+	return "", nil
+}
@@ -5,7 +5,116 @@
 
 package main
 
-// Handle "neph scrub host"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Handle "neph scrub host [--yes]"
 func commandScrub(host string, options []string) Exitcode {
-	return SUCCESS
+
+	// A decommissioned host usually can't be resolved anymore, so it is passed along as the first option
+	if isLocalhost(host) && len(options) > 0 && !isOption(options[0]) {
+		host = options[0]
+		options = options[1:]
+	}
+	if isLocalhost(host) {
+		fmt.Printf("neph scrub requires the name of a former remote host\n")
+		return CLI_BAD_ARGUMENTS
+	}
+	// the name is matched against file names, so it must not be able to name a path or a hidden file
+	if host == "" || strings.HasPrefix(host, ".") || strings.Contains(host, "/") {
+		fmt.Printf("'%s' is not a host name\n", host)
+		return CLI_BAD_ARGUMENTS
+	}
+
+	hostFiles, exitCode := findHostFiles(host)
+	if exitCode != SUCCESS {
+		return exitCode
+	}
+	hasEntry := hasHostnameEntry(host)
+
+	if len(hostFiles) == 0 && !hasEntry {
+		fmt.Printf("nothing in %s belongs to %s\n", NEPH_CONF_DIR, host)
+		return SUCCESS
+	}
+
+	fmt.Printf("The following will be removed from this device:\n")
+	for _, path := range hostFiles {
+		fmt.Printf("    %s\n", path)
+	}
+	if hasEntry {
+		fmt.Printf("    the '%s' entry in %s\n", host, HOSTNAMES_CONF)
+	}
+
+	if !hasOption(options, "--yes") && !confirm("Proceed?") {
+		fmt.Printf("scrub cancelled, nothing was removed\n")
+		return SUCCESS
+	}
+
+	exitCode = SUCCESS
+	for _, path := range hostFiles {
+		if err := os.RemoveAll(path); err != nil {
+			fmt.Printf("unable to remove %s: %v\n", path, err)
+			exitCode = FS_FAILURE
+			continue
+		}
+		fmt.Printf("%-10s %s\n", "deleted", path)
+	}
+	if hasEntry {
+		if err := removeHostnameEntry(host); err != nil {
+			fmt.Printf("unable to remove '%s' from %s: %v\n", host, HOSTNAMES_CONF, err)
+			return FS_FAILURE
+		}
+		fmt.Printf("%-10s '%s' entry in %s\n", "deleted", host, HOSTNAMES_CONF)
+	}
+	return exitCode
+}
+
+// Find the per-host files and directories in /etc/neph/conf that belong to the given host.
+// These are named after the host, either exactly ("nk024") or with an extension ("nk024.conf")
+// Returns the paths in sorted order
+func findHostFiles(host string) ([]string, Exitcode) {
+	var hostFiles []string
+
+	err := filepath.Walk(NEPH_CONF_DIR, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// the directory being searched is never itself a per-host file
+		if path == NEPH_CONF_DIR || path == HOSTNAMES_CONF {
+			return nil
+		}
+		name := info.Name()
+		if name == host || strings.HasPrefix(name, host+".") {
+			hostFiles = append(hostFiles, path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("unable to search %s: %v\n", NEPH_CONF_DIR, err)
+		return nil, FS_FAILURE
+	}
+
+	sort.Strings(hostFiles)
+	return hostFiles, SUCCESS
+}
+
+// Ask the user a yes/no question on the terminal
+// Returns true only if the answer was yes, and false if neph is interrupted before it is given
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := readLine()
+	if err != nil {
+		fmt.Printf("\n")
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
@@ -6,50 +6,19 @@
 package main
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
 )
 
-func writeHello(host string) Exitcode {
-	clientConn, exitCode := connectViaSSH(host)
-	if exitCode != SUCCESS {
-		return exitCode
-	}
-	defer clientConn.Close()
-
-	// open an SFTP session over an existing ssh connection.
+// open an SFTP session over an existing SSH connection
+// Only hostSFTP calls this; the connection manager keeps the sftp.Client and closes it in closeAllConnections
+func openSFTP(clientConn *ssh.Client) (*sftp.Client, Exitcode) {
 	sftpClient, err := sftp.NewClient(clientConn)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer sftpClient.Close()
-
-	// walk a directory
-	w := sftpClient.Walk("/home/fuji1021")
-	for w.Step() {
-		if w.Err() != nil {
-			continue
-		}
-		log.Println(w.Path())
-	}
-
-	// leave your mark
-	f, err := sftpClient.Create("hello.txt") // in /root/hello.txt
-	if err != nil {
-		log.Fatal(err)
-	}
-	if _, err := f.Write([]byte("Hello world!")); err != nil {
-		log.Fatal(err)
-	}
-	f.Close()
-
-	// check it's there
-	fi, err := sftpClient.Lstat("hello.txt")
-	if err != nil {
-		log.Fatal(err)
+		fmt.Printf("failed to start SFTP subsystem: %v\n", err)
+		return nil, SSH_SESSION_FAILURE
 	}
-	log.Println(fi)
-
-	return SUCCESS
+	return sftpClient, SUCCESS
 }
@@ -0,0 +1,195 @@
+//=============================================================================
+// File:     ssh-auth.go
+// Contents: Authenticate with ssh-agent and passphrase protected private keys
+//=============================================================================
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Get the keys to authenticate with the host, in the order they are offered to it
+// First come the keys held by a running ssh-agent, when SSH_AUTH_SOCK is set,
+// then the host's identity-file, or else the first of SSH_IDENTITY_FILES that exists.
+// The identity file may be omitted when ssh-agent holds at least one key.
+// The caller must call the returned function once the connection is authenticated, to disconnect from ssh-agent.
+func authSigners(host *Host) ([]ssh.Signer, func(), Exitcode) {
+	signers, agentConn := agentSigners()
+	closeAgent := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+
+	identityFile := host.identityFile
+	if identityFile == "" {
+		var err error
+		identityFile, err = findIdentityFile()
+		if err != nil {
+			if len(signers) > 0 {
+				return signers, closeAgent, SUCCESS
+			}
+			fmt.Printf("%v, and no ssh-agent keys are available\n", err)
+			closeAgent()
+			return nil, nil, SSH_LOCAL_CONFIGURATION_FAILURE
+		}
+	}
+
+	signer, exitCode := loadIdentity(identityFile)
+	if exitCode != SUCCESS {
+		closeAgent()
+		return nil, nil, exitCode
+	}
+	return append(signers, signer), closeAgent, SUCCESS
+}
+
+// Get the keys held by the ssh-agent listening on SSH_AUTH_SOCK
+// Returns no keys if the agent isn't running; the connection must be kept open while the keys are in use
+func agentSigners() ([]ssh.Signer, io.Closer) {
+	socket := os.Getenv(SSH_AUTH_SOCK_ENV)
+	if socket == "" {
+		return nil, nil
+	}
+	agentConn, err := net.Dial("unix", socket)
+	if err != nil {
+		fmt.Printf("ignoring ssh-agent at %s: %v\n", socket, err)
+		return nil, nil
+	}
+	signers, err := agent.NewClient(agentConn).Signers()
+	if err != nil {
+		fmt.Printf("ignoring ssh-agent at %s: %v\n", socket, err)
+		agentConn.Close()
+		return nil, nil
+	}
+	if verbose {
+		fmt.Printf("ssh-agent holds %d key(s)\n", len(signers))
+	}
+	return signers, agentConn
+}
+
+// The identity files decrypted so far, so that the passphrase of each is only asked for once,
+// even when several hosts are connected to at the same time
+var decryptedIdentities = make(map[string]ssh.Signer)
+var decryptedIdentitiesMutex sync.Mutex
+
+// Decrypt a passphrase protected private key
+func decryptIdentity(identityFile string, privateKey []byte) (ssh.Signer, error) {
+	decryptedIdentitiesMutex.Lock()
+	defer decryptedIdentitiesMutex.Unlock()
+
+	if signer, ok := decryptedIdentities[identityFile]; ok {
+		return signer, nil
+	}
+	passphrase, err := identityPassphrase(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(privateKey, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	decryptedIdentities[identityFile] = signer
+	return signer, nil
+}
+
+// Get the passphrase of the identity file from the NEPH_PASSPHRASE environment variable,
+// or else from the file named by the NEPH_PASSPHRASE_FILE environment variable,
+// or else by prompting for it at the terminal
+func identityPassphrase(identityFile string) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(NEPH_PASSPHRASE_ENV); ok {
+		return []byte(passphrase), nil
+	}
+
+	if passphraseFile := os.Getenv(NEPH_PASSPHRASE_FILE_ENV); passphraseFile != "" {
+		contents, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the passphrase from %s: %w", passphraseFile, err)
+		}
+		return bytes.TrimRight(contents, "\r\n"), nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(stdin) {
+		return nil, fmt.Errorf("%s is passphrase protected, set %s or %s to provide the passphrase", identityFile, NEPH_PASSPHRASE_ENV, NEPH_PASSPHRASE_FILE_ENV)
+	}
+	fmt.Printf("Enter passphrase for %s: ", identityFile)
+	passphrase, err := readPassword(stdin)
+	fmt.Printf("\n")
+	if err != nil {
+		return nil, err
+	}
+	return passphrase, nil
+}
+
+// The passphraseSigner type defers decrypting an identity file until the server accepts its public key,
+// so that the passphrase isn't asked for when an ssh-agent key is used instead
+type passphraseSigner struct {
+	identityFile string
+	privateKey   []byte        // the encrypted contents of the identity file
+	publicKey    ssh.PublicKey // stored unencrypted alongside the private key, in the OpenSSH format
+	signer       ssh.Signer    // nil until decrypted
+}
+
+func (s *passphraseSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *passphraseSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	if err := s.decrypt(); err != nil {
+		return nil, err
+	}
+	return s.signer.Sign(rand, data)
+}
+
+// Sign with the given algorithm, so that an RSA key can sign with rsa-sha2-256 or rsa-sha2-512
+// when the server lists them in server-sig-algs, rather than with the SHA-1 of ssh-rsa
+func (s *passphraseSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if err := s.decrypt(); err != nil {
+		return nil, err
+	}
+	algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("private key %s can't sign with %s", s.identityFile, algorithm)
+	}
+	return algorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// Decrypt the identity file, the first time that a signature is needed
+func (s *passphraseSigner) decrypt() error {
+	if s.signer != nil {
+		return nil
+	}
+	signer, err := decryptIdentity(s.identityFile, s.privateKey)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt private key %s: %w", s.identityFile, err)
+	}
+	s.signer = signer
+	return nil
+}
+
+// Parse a private key, which may be passphrase protected
+func parseIdentity(identityFile string, privateKey []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	var passphraseMissing *ssh.PassphraseMissingError
+	if !errors.As(err, &passphraseMissing) {
+		return signer, err
+	}
+
+	// a PEM key doesn't reveal its public key without the passphrase, so it must be decrypted now
+	if passphraseMissing.PublicKey == nil {
+		return decryptIdentity(identityFile, privateKey)
+	}
+	return &passphraseSigner{identityFile: identityFile, privateKey: privateKey, publicKey: passphraseMissing.PublicKey}, nil
+}
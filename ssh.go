@@ -8,98 +8,243 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// The --connect-timeout and --retries options, which override the defaults and the hosts' connect-timeout settings
+var connectTimeoutOption time.Duration = -1
+var connectRetries int = SSH_CONNECT_RETRIES
+
+// Read the --connect-timeout and --retries options from the command line
+func parseConnectionOptions(args []string) Exitcode {
+	if value := optionValue(args, "--connect-timeout", ""); value != "" {
+		timeout, err := parseTimeout(value)
+		if err != nil || timeout < 0 {
+			fmt.Printf("--connect-timeout '%s' is not a number of seconds or a duration like 1m30s\n", value)
+			return CLI_BAD_ARGUMENTS
+		}
+		connectTimeoutOption = timeout
+	}
+	if value := optionValue(args, "--retries", ""); value != "" {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			fmt.Printf("--retries '%s' is not a number of retries\n", value)
+			return CLI_BAD_ARGUMENTS
+		}
+		connectRetries = retries
+	}
+	return SUCCESS
+}
+
+// The longest to wait for a connection to the host to be established, or 0 to wait indefinitely
+func connectTimeout(host *Host) time.Duration {
+	if connectTimeoutOption >= 0 {
+		return connectTimeoutOption
+	}
+	return host.connectTimeout
+}
+
 // connect to remote host via SSH
 // returns a clientConnection and an exitCode
 // The caller must Close the ssh.Clinet connection when finished using it
-func connectViaSSH(host string) (*ssh.Client, Exitcode) {
+func connectViaSSH(hostname string) (*ssh.Client, Exitcode) {
 
-	// The server can be contacted, without authentication, using the ssh-keyscan utility, which will
-	// retreive its public host key, which is stored on the server at /etc/ssh/ssh_host_rsa_key.pub
-	// The -t flag specifies the key type: rsa, ecdsa, ed25519
-	// The return value is a string with three parts: hostname, key type, hostPublicKey, like "nk024 ssh-rsa AAAAB3Nz...CjV+IgUn"
-	sshKeyscanPath, err := findOrInstall("ssh-keyscan", "openssh-clients")
-	if err != nil {
-		fmt.Printf("ssh-keyscan utility not found and not installed: %v\n", err)
-		return nil, SSH_LOCAL_CONFIGURATION_FAILURE
+	// The address, port, user, identity file and timeout come from the host's entry in the hostnames figtree,
+	// or are the defaults for a host found in /etc/hosts or DNS
+	host, exitCode := resolveTargetHost(hostname)
+	if exitCode == HOST_NOT_FOUND {
+		hostPrintf(hostname, "unable to resolve %s using %s, %s or DNS\n", hostname, HOSTNAMES_CONF, ETC_HOSTS)
 	}
-	knownHostsEntry, err := exec.Command(sshKeyscanPath, "-t", "rsa", host).Output()
-	if err != nil {
-		fmt.Printf("ssh-keyscan was not able to obtain RSA type public host key, from %s: %v\n", host, err)
-		fmt.Print("check the remote host's configuration at /etc/ssh/sshd_config and make sure the entry 'HostKey /etc/ssh/ssh_host_rsa_key' references an OPENSSH PRIVATE KEY\n")
-		return nil, SSH_REMOTE_CONFIGURATION_FAILURE
+	if exitCode != SUCCESS {
+		return nil, exitCode
 	}
 
-	// A host key is a cryptographic key used for authenticating computers in the SSH protocol.
-	// Host keys are key pairs, typically using the RSA, DSA, or ECDSA algorithms.
-	// Public host keys are stored on and/or distributed to SSH clients,
-	// and private keys are stored on SSH servers.
-	_, _, hostPublicKey, _, _, err := ssh.ParseKnownHosts([]byte(knownHostsEntry))
-	if err != nil {
-		fmt.Printf("failed to parse knownHostsEntry %s: %v\n", knownHostsEntry, err)
-		return nil, SSH_REMOTE_CONFIGURATION_FAILURE
+	// Transient failures, like a refused connection or a timeout, are retried with exponential backoff.
+	// Anything else, like a rejected key or a changed host key, won't be fixed by trying again.
+	delay := SSH_RETRY_INITIAL_DELAY
+	for attempt := 1; ; attempt++ {
+		clientConn, exitCode := dialHost(host, effectiveVia(host), []string{host.hostname})
+		if exitCode != SSH_CONNECTION_FAILURE {
+			return clientConn, exitCode
+		}
+		if attempt > connectRetries {
+			hostPrintf(hostname, "unable to connect to %s, giving up after %d attempt(s)\n", hostname, attempt)
+			return nil, exitCode
+		}
+		hostPrintf(hostname, "retrying %s in %v\n", hostname, delay)
+		select {
+		case <-time.After(delay):
+		case <-interrupted:
+			return nil, RUN_INTERRUPTED
+		}
+		delay *= 2
+		if delay > SSH_RETRY_MAX_DELAY {
+			delay = SSH_RETRY_MAX_DELAY
+		}
 	}
+}
 
-	// Read the contents of the root user's PEM encoded private key file on the local host
-	userPrivateKey, err := ioutil.ReadFile("/root/.ssh/neph-rsa-private-key")
+// Connect and authenticate to the host, tunnelling through the jump host named by via, if not empty
+// chain lists the hosts already on the path, to detect jump hosts that lead back to themselves
+// Returns SSH_CONNECTION_FAILURE only for failures that may succeed when retried
+func dialHost(host *Host, via string, chain []string) (*ssh.Client, Exitcode) {
+
+	// The server's host key is verified against /etc/neph/known_hosts, trusting it on first contact
+	verifier, err := newHostKeyVerifier(host.hostname, host.knownHostsAddress())
 	if err != nil {
-		fmt.Printf("unable to read PEM encoded private key %s: %v\n", "/root/.ssh/neph-rsa-private-key", err)
+		hostPrintf(host.hostname, "unable to read known host keys from %s: %v\n", KNOWN_HOSTS_FILE, err)
 		return nil, SSH_LOCAL_CONFIGURATION_FAILURE
 	}
-	// Parse the PEM encoded file to get the signer
-	signer, err := ssh.ParsePrivateKey(userPrivateKey)
-	if err != nil {
-		fmt.Printf("unable to parse PEM encoded private key %s: %v\n", userPrivateKey, err)
-		return nil, SSH_LOCAL_CONFIGURATION_FAILURE
+
+	signers, closeAgent, exitCode := authSigners(host)
+	if exitCode != SUCCESS {
+		return nil, exitCode
 	}
+	defer closeAgent()
 
 	// On the remote server, the public key must be copied to a file within the user's home directory at /root/. ssh/authorized_keys.
 	// (With Digital Ocean, this is done during droplet provisioning.)
 	// The authorized_keys file contains a list of public keys, one-per-line, that are authorized to log into this account.
 	clientConfig := &ssh.ClientConfig{
-		User: "root",
+		User: host.user,
 		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
+			ssh.PublicKeys(signers...),
 		},
-		HostKeyCallback: ssh.FixedHostKey(hostPublicKey),
+		HostKeyCallback:   verifier.callback,
+		HostKeyAlgorithms: verifier.algorithms(),
+	}
+
+	conn, jumpClient, exitCode := openTransport(host, via, chain)
+	if exitCode != SUCCESS {
+		return nil, exitCode
+	}
+
+	// a server that accepts the TCP connection but never completes the handshake is abandoned after the timeout
+	var timer *time.Timer
+	if timeout := connectTimeout(host); timeout > 0 {
+		timer = time.AfterFunc(timeout, func() { conn.Close() })
+	}
+	stopWatching := closeOnInterrupt(conn)
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host.dialAddress(), clientConfig)
+	timedOut := timer != nil && !timer.Stop()
+	stopWatching()
+	if err != nil {
+		conn.Close()
+		if jumpClient != nil {
+			jumpClient.Close()
+		}
+	}
+	if verifier.mismatch {
+		return nil, SSH_REMOTE_CONFIGURATION_FAILURE
+	}
+	if err != nil && isInterrupted() {
+		return nil, RUN_INTERRUPTED
+	}
+	if timedOut {
+		hostPrintf(host.hostname, "failed to dial %s: no SSH handshake within %v\n", host.dialAddress(), connectTimeout(host))
+		return nil, SSH_CONNECTION_FAILURE
 	}
-	hostWithPort := host + ":22"
-	clientConn, err := ssh.Dial("tcp", hostWithPort, clientConfig)
 	if err != nil {
-		fmt.Printf("failed to dial %s: %v\n", hostWithPort, err)
+		hostPrintf(host.hostname, "failed to dial %s: %v\n", host.dialAddress(), err)
+		if !isTransientHandshakeError(err) {
+			return nil, SSH_REMOTE_CONFIGURATION_FAILURE
+		}
 		return nil, SSH_CONNECTION_FAILURE
 	}
 
+	clientConn := ssh.NewClient(sshConn, chans, reqs)
+	go keepAlive(clientConn, host.hostname)
+	if jumpClient != nil {
+		go closeWhenDone(clientConn, jumpClient)
+	}
 	return clientConn, SUCCESS
 }
 
+// Returns true if the handshake failed because the connection was lost,
+// rather than because the server rejected the keys or couldn't agree on the algorithms to use
+// The ssh package reports these as text, so they can only be recognized by their wording
+func isTransientHandshakeError(err error) bool {
+	message := err.Error()
+	for _, transient := range []string{"EOF", "connection reset", "broken pipe", "i/o timeout"} {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the private key that neph authenticates with
+// Returns the first of SSH_IDENTITY_FILES that exists
+func findIdentityFile() (string, error) {
+	for _, identityFile := range SSH_IDENTITY_FILES {
+		if _, err := os.Stat(identityFile); err == nil {
+			return identityFile, nil
+		}
+	}
+	return "", fmt.Errorf("no private key found, expected one of %s", strings.Join(SSH_IDENTITY_FILES, ", "))
+}
+
+// Read a private key file and parse it to get the signer
+// The key may be ed25519, ECDSA or RSA, in either OpenSSH or PEM format, and may be passphrase protected
+func loadIdentity(identityFile string) (ssh.Signer, Exitcode) {
+	userPrivateKey, err := ioutil.ReadFile(identityFile)
+	if err != nil {
+		fmt.Printf("unable to read private key %s: %v\n", identityFile, err)
+		return nil, SSH_LOCAL_CONFIGURATION_FAILURE
+	}
+	signer, err := parseIdentity(identityFile, userPrivateKey)
+	if err != nil {
+		fmt.Printf("unable to parse private key %s: %v\n", identityFile, err)
+		return nil, SSH_LOCAL_CONFIGURATION_FAILURE
+	}
+	return signer, SUCCESS
+}
+
 // Get the path to the specified tool. If it is not found, attempt to install it.
 // cliTool is the executable file name
-// distributionPackage is the DNF package that installs the executable file
+// distributionPackages maps a distribution's os-release ID, like "ubuntu", or a package manager, like "apt-get",
+// to the package that installs the executable, which is assumed to be named after the executable when neither is listed
 // returns the path to the executable
 // returns an error if it can't be found and wasn't installed
-func findOrInstall(cliTool string, distributionPackage string) (string, error) {
+func findOrInstall(cliTool string, distributionPackages map[string]string) (string, error) {
 	cliToolPath, err := exec.LookPath(cliTool)
+	if err == nil {
+		return cliToolPath, nil
+	}
+	if noInstall {
+		fmt.Printf("%s is not installed, and --no-install forbids installing it\n", cliTool)
+		return "", err
+	}
+
+	manager, distro, err := detectPackageManager()
 	if err != nil {
-		fmt.Printf("%s\nAttempting to install %s...\n", err, cliTool)
-
-		cmd := exec.Command("dnf", "install", "-y", distributionPackage)
-		stdout, err := cmd.Output()
-		fmt.Print(string(stdout))
-		if err != nil {
-			fmt.Printf("installation of %s failed\n%v", distributionPackage, err)
-			return "", err
-		}
+		fmt.Printf("unable to install %s: %v\n", cliTool, err)
+		return "", err
+	}
+	distributionPackage, ok := distributionPackages[distro]
+	if !ok {
+		distributionPackage, ok = distributionPackages[manager.name]
+	}
+	if !ok {
+		distributionPackage = cliTool
+	}
 
-		cliToolPath, err = exec.LookPath(cliTool)
-		if err != nil {
-			fmt.Printf("%s not found\n %v", cliTool, err)
-			return "", err
-		}
+	fmt.Printf("%s is not installed, installing package %s with %s\n", cliTool, distributionPackage, manager.name)
+	if err := manager.installPackage(distributionPackage); err != nil {
+		fmt.Printf("installation of %s failed\n%v\n", distributionPackage, err)
+		return "", err
+	}
+
+	cliToolPath, err = exec.LookPath(cliTool)
+	if err != nil {
+		fmt.Printf("%s not found after installing %s\n%v\n", cliTool, distributionPackage, err)
+		return "", err
 	}
 	return cliToolPath, nil
 }
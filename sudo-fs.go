@@ -0,0 +1,234 @@
+//=============================================================================
+// File:     sudo-fs.go
+// Contents: Write root-owned files on a remote host that is logged into as an unprivileged user
+//=============================================================================
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// Get the file system of the remote host, as seen by root
+// When the host is logged into as root, SFTP is used directly, otherwise file operations go through sudo
+func remoteFileSystem(hostname string) (syncFS, Exitcode) {
+	host, exitCode := resolveTargetHost(hostname)
+	if exitCode != SUCCESS {
+		return nil, exitCode
+	}
+	sftpClient, exitCode := hostSFTP(hostname)
+	if exitCode != SUCCESS {
+		return nil, exitCode
+	}
+	if !needsSudo(host) {
+		return &remoteFS{sftpClient, hostname}, SUCCESS
+	}
+
+	clientConn, exitCode := hostConnection(hostname)
+	if exitCode != SUCCESS {
+		return nil, exitCode
+	}
+	return &sudoFS{remoteFS{sftpClient, hostname}, clientConn, host}, SUCCESS
+}
+
+// The file system of a remote host that is logged into as an unprivileged user
+// The user can't write to /etc/neph, /var/neph or /usr/bin, or even read the files there, so every operation
+// is carried out by a shell command run through sudo. File contents are uploaded over SFTP to a staging file
+// in the user's home directory, which sudo then installs in place.
+type sudoFS struct {
+	remoteFS
+	clientConn *ssh.Client
+	settings   *Host
+}
+
+// Run a shell command as root, returning its output
+func (s *sudoFS) run(command string) ([]byte, error) {
+	var stdout bytes.Buffer
+	err := runPrivileged(s.clientConn, s.settings, command, &stdout)
+	return stdout.Bytes(), err
+}
+
+// Walk the tree below root, skipping hidden files and directories
+// Returns a map of paths relative to root => FileInfo
+func (s *sudoFS) List(root string) (map[string]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+
+	// one line per entry: raw mode in hex, modification time, size, path
+	// find's -printf is GNU only, but stat -c is understood by GNU coreutils and BusyBox alike
+	// A missing root is reported with an exit status that neither sudo nor find uses
+	const rootMissing = 100
+	quoted := shellQuote(root)
+	out, err := s.run(fmt.Sprintf(`test -d %s || exit %d; find %s -mindepth 1 -exec stat -c '%%f %%Y %%s %%n' {} +`, quoted, rootMissing, quoted))
+	var exitError *ssh.ExitError
+	if errors.As(err, &exitError) && exitError.ExitStatus() == rootMissing {
+		return entries, &os.PathError{Op: "list", Path: root, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return entries, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		rel, info, err := parseStatEntry(scanner.Text(), root)
+		if err != nil {
+			return entries, fmt.Errorf("unable to list %s: %w", root, err)
+		}
+		if hasHiddenComponent(rel) {
+			continue
+		}
+		entries[rel] = info
+	}
+	return entries, nil
+}
+
+// Read the file, which is small enough to be held in memory, like every config and script
+func (s *sudoFS) Open(filePath string) (io.ReadCloser, error) {
+	out, err := s.run("cat " + shellQuote(filePath))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(out)), nil
+}
+
+// Start writing the file, by way of a private staging file in the user's home directory
+func (s *sudoFS) Create(filePath string) (io.WriteCloser, error) {
+	home, err := s.client.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	stagedPath := path.Join(home, fmt.Sprintf(".neph-staged-%d", time.Now().UnixNano()))
+	file, err := s.client.OpenFile(stagedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, err
+	}
+	// the contents are secrets as often as not, so no one else may read them while they are staged
+	if err := file.Chmod(NEPH_CONF_FILE_MODE); err != nil {
+		file.Close()
+		s.client.Remove(stagedPath)
+		return nil, err
+	}
+	return &stagedFile{file, s, stagedPath, filePath}, nil
+}
+
+func (s *sudoFS) MkdirAll(dirPath string) error {
+	quoted := shellQuote(dirPath)
+	_, err := s.run(fmt.Sprintf("mkdir -p %s && chmod %o %s", quoted, NEPH_DIR_MODE, quoted))
+	return err
+}
+
+func (s *sudoFS) Remove(filePath string) error {
+	quoted := shellQuote(filePath)
+	_, err := s.run(fmt.Sprintf("if [ -d %s ]; then rmdir %s; else rm %s; fi", quoted, quoted, quoted))
+	return err
+}
+
+func (s *sudoFS) Rename(oldPath string, newPath string) error {
+	_, err := s.run("mv -f " + shellQuote(oldPath) + " " + shellQuote(newPath))
+	return err
+}
+
+func (s *sudoFS) Chmod(filePath string, mode os.FileMode) error {
+	_, err := s.run(fmt.Sprintf("chmod %o %s", mode.Perm(), shellQuote(filePath)))
+	return err
+}
+
+func (s *sudoFS) Chtimes(filePath string, atime time.Time, mtime time.Time) error {
+	stamp := mtime.UTC().Format("200601021504.05")
+	_, err := s.run(fmt.Sprintf("TZ=UTC0 touch -t %s %s", stamp, shellQuote(filePath)))
+	return err
+}
+
+// The stagedFile type is a file being uploaded to a staging location,
+// which is installed at its real location, owned by root, when it is closed
+type stagedFile struct {
+	*sftp.File
+	fs         *sudoFS
+	stagedPath string
+	filePath   string
+}
+
+func (f *stagedFile) Close() error {
+	defer f.fs.client.Remove(f.stagedPath)
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	command := fmt.Sprintf("install -m %o %s %s", NEPH_CONF_FILE_MODE, shellQuote(f.stagedPath), shellQuote(f.filePath))
+	_, err := f.fs.run(command)
+	return err
+}
+
+// Returns true if any component of the relative path is hidden
+func hasHiddenComponent(rel string) bool {
+	for _, component := range strings.Split(rel, "/") {
+		if isHiddenFile(component) {
+			return true
+		}
+	}
+	return false
+}
+
+// The sudoFileInfo type describes a remote file, as reported by stat
+type sudoFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *sudoFileInfo) Name() string       { return i.name }
+func (i *sudoFileInfo) Size() int64        { return i.size }
+func (i *sudoFileInfo) Mode() os.FileMode  { return i.mode }
+func (i *sudoFileInfo) ModTime() time.Time { return i.modTime }
+func (i *sudoFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *sudoFileInfo) Sys() interface{}   { return nil }
+
+// Parse the raw mode, modification time, size and path that stat printed for an entry below root
+// Returns the path relative to root, and the entry's FileInfo
+func parseStatEntry(line string, root string) (string, *sudoFileInfo, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 {
+		return "", nil, fmt.Errorf("unexpected stat output '%s'", line)
+	}
+	rel := strings.TrimPrefix(fields[3], strings.TrimSuffix(root, "/")+"/")
+	if rel == fields[3] || rel == "" {
+		return "", nil, fmt.Errorf("stat output '%s' is not below %s", line, root)
+	}
+
+	rawMode, err := strconv.ParseUint(fields[0], 16, 32)
+	if err != nil {
+		return "", nil, fmt.Errorf("stat output '%s' has no valid mode: %w", line, err)
+	}
+	mode := os.FileMode(rawMode) & os.ModePerm
+	switch uint32(rawMode) & unix.S_IFMT {
+	case unix.S_IFDIR:
+		mode |= os.ModeDir
+	case unix.S_IFLNK:
+		mode |= os.ModeSymlink
+	case unix.S_IFREG:
+	default:
+		mode |= os.ModeIrregular
+	}
+
+	seconds, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("stat output '%s' has no valid modification time: %w", line, err)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("stat output '%s' has no valid size: %w", line, err)
+	}
+	return rel, &sudoFileInfo{path.Base(rel), size, mode, time.Unix(seconds, 0)}, nil
+}
@@ -0,0 +1,67 @@
+//=============================================================================
+// File:     sudo-fs_test.go
+// Contents: Tests of the remote file listing made through sudo
+//=============================================================================
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseStatEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		rel   string
+		mode  os.FileMode
+		mtime int64
+		size  int64
+	}{
+		{"directory", "41ed 1634472000 4096 /etc/neph/conf/nk024", "nk024", os.ModeDir | 0755, 1634472000, 4096},
+		{"file", "81a4 1634472001 3 /etc/neph/conf/nk024/nginx.conf", "nk024/nginx.conf", 0644, 1634472001, 3},
+		{"private file", "8180 1634472002 0 /etc/neph/conf/secret", "secret", 0600, 1634472002, 0},
+		{"symlink", "a1ff 1634472003 5 /etc/neph/conf/link", "link", os.ModeSymlink | 0777, 1634472003, 5},
+		{"fifo", "11a4 1634472004 0 /etc/neph/conf/pipe", "pipe", os.ModeIrregular | 0644, 1634472004, 0},
+		{"space in name", "81a4 1634472005 12 /etc/neph/conf/a b.conf", "a b.conf", 0644, 1634472005, 12},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rel, info, err := parseStatEntry(test.line, NEPH_CONF_DIR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rel != test.rel {
+				t.Errorf("path %q, want %q", rel, test.rel)
+			}
+			if info.Mode() != test.mode {
+				t.Errorf("mode %v, want %v", info.Mode(), test.mode)
+			}
+			if !info.ModTime().Equal(time.Unix(test.mtime, 0)) {
+				t.Errorf("modification time %v, want %v", info.ModTime().Unix(), test.mtime)
+			}
+			if info.Size() != test.size {
+				t.Errorf("size %d, want %d", info.Size(), test.size)
+			}
+		})
+	}
+}
+
+func TestParseStatEntryRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"41ed 1634472000 4096",
+		"zz 1634472000 4096 /etc/neph/conf/nk024",
+		"41ed 1634472000.5 4096 /etc/neph/conf/nk024",
+		"41ed 1634472000 big /etc/neph/conf/nk024",
+		"41ed 1634472000 4096 /etc/neph/confnk024",
+		"41ed 1634472000 4096 /var/neph/scripts/hello",
+	}
+	for _, line := range tests {
+		if _, _, err := parseStatEntry(line, NEPH_CONF_DIR); err == nil {
+			t.Errorf("parseStatEntry(%q) succeeded", line)
+		}
+	}
+}
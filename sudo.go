@@ -0,0 +1,107 @@
+//=============================================================================
+// File:     sudo.go
+// Contents: Gain root privileges on remote hosts that are logged into as an unprivileged user
+//=============================================================================
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// How sudo is told the user's password, chosen with the host's sudo setting
+const (
+	SUDO_NOPASSWD string = "nopasswd" // sudo is configured with NOPASSWD, so it must never ask
+	SUDO_PASSWORD string = "password" // the password is piped to sudo on its standard input
+)
+
+// The sudo passwords entered so far, keyed by hostname, so that each is only asked for once
+var sudoPasswords = make(map[string]string)
+var sudoPasswordsMutex sync.Mutex
+
+// Returns true if commands on the host must be run through sudo to have root privileges
+func needsSudo(host *Host) bool {
+	return host.user != "root"
+}
+
+// Wrap a shell command so that it runs on the host with root privileges
+// Returns the command line to run, and the input that must be fed to it, which holds the sudo password if one is needed
+// The password is read by sudo itself, before the command starts, so the command never sees it
+func privilegedCommand(host *Host, command string) (string, io.Reader, error) {
+	if !needsSudo(host) {
+		return command, nil, nil
+	}
+
+	if host.sudo != SUDO_PASSWORD {
+		return "sudo -n sh -c " + shellQuote(command), nil, nil
+	}
+
+	password, err := sudoPassword(host)
+	if err != nil {
+		return "", nil, err
+	}
+	// -k ignores any cached credentials, so that sudo always consumes the password line
+	return "sudo -S -k -p '' sh -c " + shellQuote(command), strings.NewReader(password + "\n"), nil
+}
+
+// Get the user's sudo password on the host from the NEPH_SUDO_PASSWORD environment variable,
+// or else by prompting for it at the terminal
+func sudoPassword(host *Host) (string, error) {
+	sudoPasswordsMutex.Lock()
+	defer sudoPasswordsMutex.Unlock()
+
+	if password, ok := sudoPasswords[host.hostname]; ok {
+		return password, nil
+	}
+	if password, ok := os.LookupEnv(NEPH_SUDO_PASSWORD_ENV); ok {
+		return password, nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(stdin) {
+		return "", fmt.Errorf("sudo on %s needs the password of %s, set %s to provide it", host.hostname, host.user, NEPH_SUDO_PASSWORD_ENV)
+	}
+	fmt.Printf("[sudo] password for %s@%s: ", host.user, host.hostname)
+	password, err := readPassword(stdin)
+	fmt.Printf("\n")
+	if err != nil {
+		return "", err
+	}
+	sudoPasswords[host.hostname] = string(password)
+	return string(password), nil
+}
+
+// Run a shell command on the remote host with root privileges, writing its output to stdout
+// Returns an error holding the command's error output if it fails
+func runPrivileged(clientConn *ssh.Client, host *Host, command string, stdout io.Writer) error {
+	privileged, stdin, err := privilegedCommand(host, command)
+	if err != nil {
+		return err
+	}
+
+	session, err := clientConn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = &stderr
+	if err := session.Run(privileged); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%s (%w)", message, err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+//=============================================================================
+// File:     sync-tree.go
+// Contents: Synchronize the configs and scripts directory trees between hosts
+//=============================================================================
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// The directory trees that are kept synchronized, and the mode given to the files within them
+var nephTrees = []struct {
+	root     string
+	fileMode os.FileMode
+}{
+	{NEPH_CONF_DIR, NEPH_CONF_FILE_MODE},
+	{NEPH_SCRIPTS_DIR, NEPH_SCRIPT_FILE_MODE},
+}
+
+// The syncFS interface is implemented by both the local file system and a remote host's file system,
+// so that push and pull can share the same synchronization logic, just in opposite directions
+type syncFS interface {
+	Name() string
+	List(root string) (map[string]os.FileInfo, error)
+	Open(path string) (io.ReadCloser, error)
+	Create(path string) (io.WriteCloser, error)
+	MkdirAll(path string) error
+	Remove(path string) error
+	Rename(oldPath string, newPath string) error
+	Chmod(path string, mode os.FileMode) error
+	Chtimes(path string, atime time.Time, mtime time.Time) error
+}
+
+// The file system of the device that neph is running on
+type localFS struct{}
+
+func (l *localFS) Name() string {
+	return "localhost"
+}
+
+// Walk the tree below root, skipping hidden files and directories
+// Returns a map of paths relative to root => FileInfo
+func (l *localFS) List(root string) (map[string]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if isHiddenFile(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entries[rel] = info
+		return nil
+	})
+	return entries, err
+}
+
+func (l *localFS) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// The file must not already exist, so that nothing else can be holding it open or have put a symlink in its place
+// It is only readable by its owner until copyFile gives it its final mode, since it may hold secrets
+func (l *localFS) Create(path string) (io.WriteCloser, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, NEPH_CONF_FILE_MODE)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(NEPH_CONF_FILE_MODE); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (l *localFS) MkdirAll(path string) error {
+	if err := os.MkdirAll(path, NEPH_DIR_MODE); err != nil {
+		return err
+	}
+	return os.Chmod(path, NEPH_DIR_MODE)
+}
+
+func (l *localFS) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (l *localFS) Rename(oldPath string, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+func (l *localFS) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+func (l *localFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+// The file system of a remote host, reached over SFTP
+type remoteFS struct {
+	client *sftp.Client
+	host   string
+}
+
+func (r *remoteFS) Name() string {
+	return r.host
+}
+
+// Walk the remote tree below root, skipping hidden files and directories
+// Returns a map of paths relative to root => FileInfo
+func (r *remoteFS) List(root string) (map[string]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+	walker := r.client.Walk(root)
+	for walker.Step() {
+		if walker.Err() != nil {
+			return entries, walker.Err()
+		}
+		path := walker.Path()
+		info := walker.Stat()
+		if path == root {
+			continue
+		}
+		if isHiddenFile(info.Name()) {
+			if info.IsDir() {
+				walker.SkipDir()
+			}
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return entries, err
+		}
+		entries[rel] = info
+	}
+	return entries, nil
+}
+
+func (r *remoteFS) Open(path string) (io.ReadCloser, error) {
+	return r.client.Open(path)
+}
+
+// Like the local file system, the file must not already exist, and is only readable by its owner
+// until copyFile gives it its final mode
+func (r *remoteFS) Create(path string) (io.WriteCloser, error) {
+	file, err := r.client.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(NEPH_CONF_FILE_MODE); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (r *remoteFS) MkdirAll(path string) error {
+	if err := r.client.MkdirAll(path); err != nil {
+		return err
+	}
+	return r.client.Chmod(path, NEPH_DIR_MODE)
+}
+
+func (r *remoteFS) Remove(path string) error {
+	return r.client.Remove(path)
+}
+
+func (r *remoteFS) Rename(oldPath string, newPath string) error {
+	return r.client.PosixRename(oldPath, newPath)
+}
+
+func (r *remoteFS) Chmod(path string, mode os.FileMode) error {
+	return r.client.Chmod(path, mode)
+}
+
+func (r *remoteFS) Chtimes(path string, atime time.Time, mtime time.Time) error {
+	return r.client.Chtimes(path, atime, mtime)
+}
+
+// Tally of what happened to each file during a synchronization
+type syncSummary struct {
+	added     int
+	updated   int
+	deleted   int
+	unchanged int
+	failed    int
+}
+
+// Print the action taken on a single file and count it
+func (s *syncSummary) report(action string, path string) {
+	switch action {
+	case "added":
+		s.added++
+	case "updated":
+		s.updated++
+	case "deleted":
+		s.deleted++
+	case "unchanged":
+		s.unchanged++
+	case "failed":
+		s.failed++
+	}
+	fmt.Printf("%-10s %s\n", action, path)
+}
+
+// Synchronize all of the neph directory trees from src to dst
+// When force is true, every file is copied without checking timestamps
+// Returns SYNC_PARTIAL_FAILURE if any file could not be copied, updated or deleted
+func syncNephTrees(src syncFS, dst syncFS, force bool) Exitcode {
+	summary := &syncSummary{}
+	for _, tree := range nephTrees {
+		syncTree(src, dst, tree.root, tree.fileMode, force, summary)
+	}
+	if isInterrupted() {
+		fmt.Printf("interrupted before every file was synchronized\n")
+		return RUN_INTERRUPTED
+	}
+
+	fmt.Printf("%d added, %d updated, %d deleted, %d unchanged, %d failed\n",
+		summary.added, summary.updated, summary.deleted, summary.unchanged, summary.failed)
+
+	if summary.failed > 0 {
+		return SYNC_PARTIAL_FAILURE
+	}
+	return SUCCESS
+}
+
+// Copy missing files, update older files, and delete obsolete files, making the dst tree below root match the src tree
+// Stops between files when neph is interrupted
+func syncTree(src syncFS, dst syncFS, root string, fileMode os.FileMode, force bool, summary *syncSummary) {
+
+	// a missing source tree is never taken to mean that everything on the destination is obsolete
+	srcEntries, err := src.List(root)
+	if err != nil {
+		fmt.Printf("unable to list %s on %s: %v\n", root, src.Name(), err)
+		summary.report("failed", root)
+		return
+	}
+	dstEntries, err := dst.List(root)
+	if errors.Is(err, os.ErrNotExist) {
+		dstEntries = make(map[string]os.FileInfo)
+	} else if err != nil {
+		fmt.Printf("unable to list %s on %s: %v\n", root, dst.Name(), err)
+		summary.report("failed", root)
+		return
+	}
+	if err := dst.MkdirAll(root); err != nil {
+		fmt.Printf("unable to create %s on %s: %v\n", root, dst.Name(), err)
+		summary.report("failed", root)
+		return
+	}
+
+	// sorting guarantees that directories are created before the files within them
+	for _, rel := range sortedKeys(srcEntries) {
+		if isInterrupted() {
+			return
+		}
+		srcInfo := srcEntries[rel]
+		dstInfo, exists := dstEntries[rel]
+		path := filepath.Join(root, rel)
+
+		if srcInfo.IsDir() {
+			// a file where the directory belongs is removed first, rather than waiting for the obsolete files
+			if exists && !dstInfo.IsDir() {
+				if err := dst.Remove(path); err != nil {
+					fmt.Printf("unable to delete %s on %s: %v\n", path, dst.Name(), err)
+					summary.report("failed", path)
+					continue
+				}
+				summary.report("deleted", path)
+				delete(dstEntries, rel)
+				exists = false
+			}
+			if !exists {
+				if err := dst.MkdirAll(path); err != nil {
+					fmt.Printf("unable to create %s on %s: %v\n", path, dst.Name(), err)
+					summary.report("failed", path)
+				}
+			}
+			continue
+		}
+
+		var action string
+		switch {
+		case !exists:
+			action = "added"
+		case dstInfo.IsDir():
+			fmt.Printf("%s is a directory on %s but a file on %s\n", path, dst.Name(), src.Name())
+			summary.report("failed", path)
+			continue
+		case force:
+			action = "updated"
+		case srcInfo.ModTime().Unix() > dstInfo.ModTime().Unix(): // SFTP only has one second resolution
+			action = "updated"
+		default:
+			if dstInfo.Mode().Perm() != fileMode {
+				if err := dst.Chmod(path, fileMode); err != nil {
+					fmt.Printf("unable to chmod %s on %s: %v\n", path, dst.Name(), err)
+				}
+			}
+			summary.report("unchanged", path)
+			continue
+		}
+
+		if err := copyFile(src, path, dst, path, srcInfo.ModTime(), fileMode); err != nil {
+			fmt.Printf("unable to copy %s from %s to %s: %v\n", path, src.Name(), dst.Name(), err)
+			summary.report("failed", path)
+			continue
+		}
+		summary.report(action, path)
+	}
+
+	// reverse sorting guarantees that files are deleted before the directories that contain them
+	obsolete := sortedKeys(dstEntries)
+	sort.Sort(sort.Reverse(sort.StringSlice(obsolete)))
+	for _, rel := range obsolete {
+		if isInterrupted() {
+			return
+		}
+		if srcInfo, ok := srcEntries[rel]; ok && srcInfo.IsDir() == dstEntries[rel].IsDir() {
+			continue
+		}
+		path := filepath.Join(root, rel)
+		if err := dst.Remove(path); err != nil {
+			fmt.Printf("unable to delete %s on %s: %v\n", path, dst.Name(), err)
+			summary.report("failed", path)
+			continue
+		}
+		if !dstEntries[rel].IsDir() {
+			summary.report("deleted", path)
+		}
+	}
+}
+
+// Copy a single file from src to dst, giving it the specified mode and modification time
+// The copy is written to a hidden temporary file beside dstPath, which is renamed over it once complete,
+// so a failure part way through leaves any existing file at dstPath as it was
+func copyFile(src syncFS, srcPath string, dst syncFS, dstPath string, mtime time.Time, mode os.FileMode) error {
+	inFile, err := src.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	tempPath := filepath.Join(filepath.Dir(dstPath), fmt.Sprintf(".%s.neph-%d", filepath.Base(dstPath), time.Now().UnixNano()))
+	outFile, err := dst.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	bRenamed := false
+	defer func() {
+		if !bRenamed {
+			dst.Remove(tempPath)
+		}
+	}()
+
+	if _, err := io.Copy(outFile, inFile); err != nil {
+		outFile.Close()
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+	if err := dst.Chmod(tempPath, mode); err != nil {
+		return err
+	}
+	if err := dst.Chtimes(tempPath, mtime, mtime); err != nil {
+		return err
+	}
+	if err := dst.Rename(tempPath, dstPath); err != nil {
+		return err
+	}
+	bRenamed = true
+	return nil
+}
+
+// Returns the keys of the map in ascending order
+func sortedKeys(entries map[string]os.FileInfo) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,165 @@
+//=============================================================================
+// File:     unified-diff.go
+// Contents: Describe the changes to a config file in unified diff format
+//=============================================================================
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The number of unchanged lines shown around each change
+const DIFF_CONTEXT_LINES int = 3
+
+// A single line of the diff, marked with ' ' if it was kept, '-' if it was deleted, or '+' if it was inserted
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// Compare the original and updated contents of the named file
+// Returns the differences in unified diff format, or an empty string if the contents are identical
+func unifiedDiff(filename string, original string, updated string) string {
+	if original == updated {
+		return ""
+	}
+
+	lines := diffLines(splitLines(original), splitLines(updated))
+
+	// oldLineNo[i] and newLineNo[i] are the number of original and updated lines that precede lines[i]
+	oldLineNo := make([]int, len(lines)+1)
+	newLineNo := make([]int, len(lines)+1)
+	for i, line := range lines {
+		oldLineNo[i+1] = oldLineNo[i]
+		newLineNo[i+1] = newLineNo[i]
+		if line.op != '+' {
+			oldLineNo[i+1]++
+		}
+		if line.op != '-' {
+			newLineNo[i+1]++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s (with DTB applied)\n", filename, filename)
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// a hunk continues through runs of unchanged lines that are too short to separate two hunks
+		last := i
+		for j := i + 1; j < len(lines); j++ {
+			if lines[j].op != ' ' {
+				if j-last-1 > 2*DIFF_CONTEXT_LINES {
+					break
+				}
+				last = j
+			}
+		}
+		first := i - DIFF_CONTEXT_LINES
+		if first < 0 {
+			first = 0
+		}
+		end := last + 1 + DIFF_CONTEXT_LINES
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		oldCount := oldLineNo[end] - oldLineNo[first]
+		newCount := newLineNo[end] - newLineNo[first]
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLineNo[first], oldCount), hunkRange(newLineNo[first], newCount))
+
+		for _, line := range lines[first:end] {
+			b.WriteByte(line.op)
+			b.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// Format the line range of one side of a hunk header, like "12,7"
+// An empty range refers to the line before it, as required by the unified diff format
+func hunkRange(precedingLines int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", precedingLines)
+	}
+	return fmt.Sprintf("%d,%d", precedingLines+1, count)
+}
+
+// Split the text into lines, each keeping its newline
+func splitLines(text string) []string {
+	var lines []string
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Find the shortest sequence of deletions and insertions that turns the original lines into the updated lines
+// The unchanged lines at the start and end are set aside first, so that the longest common subsequence
+// only needs to be computed for the region that actually changed, which for a DTB is usually small
+func diffLines(original []string, updated []string) []diffLine {
+	prefix := 0
+	for prefix < len(original) && prefix < len(updated) && original[prefix] == updated[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(original)-prefix && suffix < len(updated)-prefix &&
+		original[len(original)-1-suffix] == updated[len(updated)-1-suffix] {
+		suffix++
+	}
+	a := original[prefix : len(original)-suffix]
+	b := updated[prefix : len(updated)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	for _, text := range original[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for _, text := range original[len(original)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
@@ -0,0 +1,82 @@
+//=============================================================================
+// File:     unified-diff_test.go
+// Contents: Tests of describing the changes to a config file in unified diff format
+//=============================================================================
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The numbers from 1 to n, one per line, with the given lines replaced
+func numberedLines(n int, replaced map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if text, ok := replaced[i]; ok {
+			b.WriteString(text + "\n")
+		} else {
+			b.WriteString(strconv.Itoa(i) + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	const header = "--- app.conf\n+++ app.conf (with DTB applied)\n"
+	tests := []struct {
+		name     string
+		original string
+		updated  string
+		want     string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", ""},
+		{"both empty", "", "", ""},
+		{"one change with context", numberedLines(10, nil), numberedLines(10, map[int]string{5: "five"}),
+			"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"},
+		{"changes six lines apart merged", numberedLines(20, nil), numberedLines(20, map[int]string{3: "three", 10: "ten"}),
+			"@@ -1,13 +1,13 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n 9\n-10\n+ten\n 11\n 12\n 13\n"},
+		{"changes seven lines apart separate", numberedLines(20, nil), numberedLines(20, map[int]string{3: "three", 11: "eleven"}),
+			"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+				"@@ -8,7 +8,7 @@\n 8\n 9\n 10\n-11\n+eleven\n 12\n 13\n 14\n"},
+		{"insertion only", "a\nb\n", "a\nx\nb\n", "@@ -1,2 +1,3 @@\n a\n+x\n b\n"},
+		{"deletion only", "a\nx\nb\n", "a\nb\n", "@@ -1,3 +1,2 @@\n a\n-x\n b\n"},
+		{"empty original", "", "a\nb\n", "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"empty update", "a\nb\n", "", "@@ -1,2 +0,0 @@\n-a\n-b\n"},
+		{"no trailing newline in original", "a\nb", "a\nc\n",
+			"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n"},
+		{"trailing newline added", "a\nb", "a\nb\n",
+			"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := test.want
+			if want != "" {
+				want = header + want
+			}
+			if got := unifiedDiff("app.conf", test.original, test.updated); got != want {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestHunkRange(t *testing.T) {
+	tests := []struct {
+		precedingLines int
+		count          int
+		want           string
+	}{
+		{0, 3, "1,3"},
+		{11, 7, "12,7"},
+		{0, 0, "0,0"},
+		{4, 0, "4,0"},
+	}
+	for _, test := range tests {
+		if got := hunkRange(test.precedingLines, test.count); got != test.want {
+			t.Errorf("hunkRange(%d, %d) = %s, want %s", test.precedingLines, test.count, got, test.want)
+		}
+	}
+}